@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// minConfidence is the lowest content match that may move a thread.
+const minConfidence = 0.6
+
+// hunkContext is how many trailing lines of a diff hunk identify a location.
+const hunkContext = 3
+
+// baseContent returns the content of path without review blocks: the file the
+// comments' line numbers refer to.
+func baseContent(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	src := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	blocks, err := parseReviewBlocks(src)
+	if err != nil || len(blocks) == 0 {
+		return data, err
+	}
+	return []byte(strings.Join(removeBlocks(src, blocks), "\n") + "\n"), nil
+}
+
+// readBase returns the lines of path without review blocks.
+func readBase(path string) ([]string, error) {
+	data, err := baseContent(path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// hunkTail returns up to n new-side lines at the end of a diff hunk; the last
+// one is the commented line.
+func hunkTail(diffHunk string, n int) []string {
+	var lines []string
+	for i, l := range strings.Split(strings.TrimRight(diffHunk, "\n"), "\n") {
+		switch {
+		case i == 0 && strings.HasPrefix(l, "@@"):
+		case l == "":
+			lines = append(lines, "")
+		case l[0] == ' ' || l[0] == '+':
+			lines = append(lines, l[1:])
+		}
+	}
+	return lines[max(len(lines)-n, 0):]
+}
+
+// anchorByContent checks that line of src still holds the commented code and,
+// if not, looks for the end of the diff hunk elsewhere in src, preferring
+// matches close to line. It returns the best line with a confidence between 0
+// and 1; a hunk without content cannot be checked and confirms line as is.
+func anchorByContent(src []string, diffHunk string, line int) (int, float64) {
+	return anchorWithin(src, diffHunk, line, 1, len(src))
+}
+
+// anchorWithin is anchorByContent limited to matches ending on lines from..to.
+func anchorWithin(src []string, diffHunk string, line, from, to int) (int, float64) {
+	from, to = max(from, 1), min(to, len(src))
+	if from > to {
+		return line, 0
+	}
+	want := hunkTail(diffHunk, hunkContext)
+	if len(want) == 0 || (len(want) == 1 && strings.TrimSpace(want[0]) == "") {
+		return line, 1
+	}
+	best, bestScore := line, 0.0
+	if line >= from && line <= to {
+		bestScore = matchScore(src, want, line)
+		if bestScore == 1 {
+			return line, 1
+		}
+	}
+	for i := from; i <= to; i++ {
+		s := matchScore(src, want, i)
+		if s > bestScore || s == bestScore && s > 0 && abs(i-line) < abs(best-line) {
+			best, bestScore = i, s
+		}
+	}
+	return best, bestScore
+}
+
+// matchScore rates how well want lines up with src ending at line end. The
+// commented line counts double and must resemble its old text at all.
+func matchScore(src, want []string, end int) float64 {
+	last := similarity(want[len(want)-1], src[end-1])
+	if last < 0.5 {
+		return 0
+	}
+	total, weight := 2*last, 2.0
+	for j := 0; j < len(want)-1; j++ {
+		k := end - len(want) + 1 + j
+		if k >= 1 {
+			total += similarity(want[j], src[k-1])
+		}
+		weight++
+	}
+	return total / weight
+}
+
+// similarity compares two source lines, ignoring surrounding whitespace, by
+// their edit distance: 1 for equal lines, 0 for nothing in common.
+func similarity(a, b string) float64 {
+	ra, rb := []rune(strings.TrimSpace(a)), []rune(strings.TrimSpace(b))
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+	longer := max(len(ra), len(rb))
+	if 2*min(len(ra), len(rb)) < longer {
+		return 0 // too different in length to be worth the distance
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j], cur[j-1])+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(rb)])/float64(longer)
+}
+
+// confidenceNote describes a content-based placement for the block header.
+func confidenceNote(from, to int, score float64) string {
+	if from == to {
+		return fmt.Sprintf("%d%% match", int(score*100))
+	}
+	return fmt.Sprintf("anchored by content from L%d, %d%% match", from, int(score*100))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestAnchorByContent(t *testing.T) {
+	hunk := "@@ -1,3 +1,4 @@ func f() {\n \tx := load()\n+\tif x == nil {\n+\t\treturn errMissing"
+	tests := []struct {
+		name     string
+		src      []string
+		line     int
+		want     int
+		minScore float64
+		maxScore float64
+	}{
+		{
+			name: "confirmed",
+			src:  []string{"func f() {", "\tx := load()", "\tif x == nil {", "\t\treturn errMissing", "\t}"},
+			line: 4, want: 4, minScore: 1, maxScore: 1,
+		},
+		{
+			name: "moved",
+			src:  []string{"// new", "// lines", "func f() {", "\tx := load()", "\tif x == nil {", "\t\treturn errMissing", "\t}"},
+			line: 4, want: 6, minScore: 1, maxScore: 1,
+		},
+		{
+			name: "edited",
+			src:  []string{"func f() {", "\tx := load()", "\tif x == nil {", "\t\treturn errMissingX", "\t}"},
+			line: 4, want: 4, minScore: minConfidence, maxScore: 0.99,
+		},
+		{
+			name: "gone",
+			src:  []string{"func g() {", "\treturn", "}"},
+			line: 2, want: 2, minScore: 0, maxScore: minConfidence - 0.01,
+		},
+	}
+	for _, tt := range tests {
+		got, score := anchorByContent(tt.src, hunk, tt.line)
+		if got != tt.want || score < tt.minScore || score > tt.maxScore {
+			t.Errorf("%s: anchorByContent = %d, %.2f; want %d, %.2f-%.2f", tt.name, got, score, tt.want, tt.minScore, tt.maxScore)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if s := similarity("  foo(bar)", "foo(bar)\t"); s != 1 {
+		t.Errorf("whitespace-only difference scored %.2f", s)
+	}
+	if s := similarity("kitten", "sitting"); s < 0.5 || s > 0.6 {
+		t.Errorf("similarity(kitten, sitting) = %.2f, want 4/7", s)
+	}
+	if s := similarity("x", "a much longer line"); s != 0 {
+		t.Errorf("unrelated lines scored %.2f", s)
+	}
+}
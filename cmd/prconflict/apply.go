@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// suggestionEdit is one reviewer suggestion ready to be applied to the working tree.
+type suggestionEdit struct {
+	threadID  string
+	commentID int64
+	user      string
+	userID    int64
+	path      string
+	start     int // first replaced line, 1-based
+	line      int // last replaced line, 1-based
+	commitID  string
+	lines     []string
+}
+
+// runApplySuggestions implements `prconflict apply-suggestions`: it applies the
+// ```suggestion blocks of unresolved threads to the working tree.
+func runApplySuggestions(args []string) {
+	fset := flag.NewFlagSet("apply-suggestions", flag.ExitOnError)
+	target := addPRFlags(fset)
+	policy := addTrustFlags(fset)
+	reviewer := fset.String("reviewer", "", "Only apply suggestions by this GitHub login")
+	pathFilter := fset.String("path", "", "Only apply suggestions to this file or directory")
+	threadFilter := fset.String("thread", "", "Only apply this thread (node ID or comment ID)")
+	commit := fset.Bool("commit", false, "Create one commit with the applied suggestions")
+	message := fset.String("message", "Apply review suggestions", "Commit message used with --commit")
+	resolve := fset.Bool("resolve", false, "Resolve the threads of applied suggestions on GitHub")
+	dryRun := fset.Bool("dry-run", false, "List suggestions that would be applied without changing anything")
+	fset.Parse(args)
+	policy.use()
+
+	owner, repo, prNumber, err := target.resolve()
+	if err != nil {
+		log.Fatalf("apply-suggestions: %v", err)
+	}
+	ctx := context.Background()
+	ghREST, ghQL, err := newClients(ctx)
+	if err != nil {
+		log.Fatalf("apply-suggestions: %v", err)
+	}
+
+	unresolvedIDs, err := getUnresolvedCommentIDs(ctx, ghQL, owner, repo, prNumber)
+	if err != nil {
+		log.Fatalf("apply-suggestions: %v", err)
+	}
+	comments, err := fetchReviewComments(ctx, ghREST, owner, repo, prNumber)
+	if err != nil {
+		log.Fatalf("apply-suggestions: %v", err)
+	}
+
+	var edits []suggestionEdit
+	for _, e := range collectSuggestions(comments, unresolvedIDs) {
+		if *reviewer != "" && !strings.EqualFold(e.user, *reviewer) {
+			continue
+		}
+		if *pathFilter != "" && !pathMatches(e.path, *pathFilter) {
+			continue
+		}
+		if *threadFilter != "" && *threadFilter != e.threadID && *threadFilter != strconv.FormatInt(e.commentID, 10) {
+			continue
+		}
+		edits = append(edits, e)
+	}
+	if len(edits) == 0 {
+		log.Println("No unresolved suggestions match – nothing to apply.")
+		return
+	}
+
+	byPath := map[string][]suggestionEdit{}
+	for _, e := range edits {
+		byPath[e.path] = append(byPath[e.path], e)
+	}
+	var applied []suggestionEdit
+	var paths []string
+	for path, es := range byPath {
+		done, err := applySuggestions(path, es, *dryRun)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+		}
+		if len(done) > 0 {
+			applied = append(applied, done...)
+			paths = append(paths, path)
+		}
+	}
+	if len(applied) == 0 {
+		log.Println("No suggestion could be applied.")
+		os.Exit(1)
+	}
+	if *dryRun {
+		return
+	}
+	fmt.Printf("Applied %d suggestion(s) to %d file(s).\n", len(applied), len(paths))
+
+	if *commit {
+		if err := commitSuggestions(paths, *message, applied); err != nil {
+			log.Fatalf("commit: %v", err)
+		}
+	}
+	if *resolve {
+		resolver := NewGraphQLResolver(ghQL)
+		for _, e := range applied {
+			if err := resolver.ResolveThread(ctx, e.threadID); err != nil {
+				log.Printf("%s:%d: %v", e.path, e.line, err)
+			}
+		}
+	}
+}
+
+// collectSuggestions returns the latest suggestion of every unresolved thread
+// that is still positioned on the PR head.
+func collectSuggestions(comments []*github.PullRequestComment, unresolved map[int64]string) []suggestionEdit {
+	threads := map[string][]*github.PullRequestComment{}
+	var order []string
+	for _, c := range comments {
+		id, ok := unresolved[c.GetID()]
+		if !ok {
+			continue
+		}
+		if threads[id] == nil {
+			order = append(order, id)
+		}
+		threads[id] = append(threads[id], c)
+	}
+
+	var edits []suggestionEdit
+	for _, id := range order {
+		cs := threads[id]
+		sort.SliceStable(cs, func(i, j int) bool { return cs[i].GetCreatedAt().Before(cs[j].GetCreatedAt().Time) })
+		for i := len(cs) - 1; i >= 0; i-- {
+			c := cs[i]
+			lines, ok := parseSuggestion(c.GetBody())
+			if !ok {
+				continue
+			}
+			if !trust.allows(c.GetAuthorAssociation()) {
+				log.Printf("suggestion %d by %s (%s) is from an untrusted author, skipping", c.GetID(), neutralize(c.GetUser().GetLogin()), nonEmpty(c.GetAuthorAssociation()))
+				continue
+			}
+			if c.Path == nil || c.Line == nil {
+				log.Printf("suggestion %d is outdated, skipping", c.GetID())
+				break
+			}
+			if hostile := hostileContent(strings.Join(lines, "\n")); len(hostile) > 0 {
+				log.Printf("suggestion %d by %s contains %s, skipping", c.GetID(), neutralize(c.GetUser().GetLogin()), strings.Join(hostile, ", "))
+				break
+			}
+			start := c.GetStartLine()
+			if start == 0 || start > c.GetLine() {
+				start = c.GetLine()
+			}
+			edits = append(edits, suggestionEdit{
+				threadID:  id,
+				commentID: c.GetID(),
+				user:      nonEmpty(c.GetUser().GetLogin()),
+				userID:    c.GetUser().GetID(),
+				path:      c.GetPath(),
+				start:     start,
+				line:      c.GetLine(),
+				commitID:  c.GetCommitID(),
+				lines:     lines,
+			})
+			break
+		}
+	}
+	return edits
+}
+
+// applySuggestions applies edits to one file and returns the ones that were
+// applied. Edits whose lines changed since the comment's commit are refused.
+func applySuggestions(path string, edits []suggestionEdit, dry bool) ([]suggestionEdit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	src := strings.Split(string(data), "\n")
+	if blocks, err := parseReviewBlocks(src); err != nil || len(blocks) > 0 {
+		return nil, fmt.Errorf("file contains conflict markers – run prconflict clean first")
+	}
+
+	// Bottom-up, so earlier line numbers stay valid.
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	var applied []suggestionEdit
+	limit := len(src) + 1
+	for _, e := range edits {
+		if e.line > len(src) {
+			log.Printf("%s:%d: line vanished, skipping", path, e.line)
+			continue
+		}
+		if e.line >= limit {
+			log.Printf("%s:%d: overlaps another suggestion, skipping", path, e.line)
+			continue
+		}
+		if err := checkUnchanged(e, src); err != nil {
+			log.Printf("%s:%d: %v – refusing suggestion by %s", path, e.start, err, e.user)
+			continue
+		}
+		repl := e.lines
+		if strings.HasSuffix(src[e.line-1], "\r") {
+			repl = make([]string, len(e.lines))
+			for i, l := range e.lines {
+				repl[i] = l + "\r"
+			}
+		}
+		if dry {
+			fmt.Printf("%s:%d-%d (%s): %d line(s) → %d line(s)\n", path, e.start, e.line, e.user, e.line-e.start+1, len(repl))
+		}
+		src = append(src[:e.start-1], append(append([]string(nil), repl...), src[e.line:]...)...)
+		applied = append(applied, e)
+		limit = e.start
+	}
+	if dry || len(applied) == 0 {
+		return applied, nil
+	}
+	return applied, writeLines(path, src)
+}
+
+// checkUnchanged verifies that the lines a suggestion replaces are the same in
+// src as at the commit the comment was made on.
+func checkUnchanged(e suggestionEdit, src []string) error {
+	out, err := exec.Command("git", "show", e.commitID+":"+e.path).Output()
+	if err != nil {
+		return fmt.Errorf("commit %.7s not available locally (try git fetch)", e.commitID)
+	}
+	then := strings.Split(string(out), "\n")
+	if e.line > len(then) {
+		return fmt.Errorf("line %d not found at %.7s", e.line, e.commitID)
+	}
+	for i := e.start - 1; i < e.line; i++ {
+		if strings.TrimSuffix(then[i], "\r") != strings.TrimSuffix(src[i], "\r") {
+			return fmt.Errorf("line %d changed since %.7s", i+1, e.commitID)
+		}
+	}
+	return nil
+}
+
+// commitSuggestions commits paths with one Co-authored-by trailer per reviewer,
+// like GitHub's own "Commit suggestion" button.
+func commitSuggestions(paths []string, message string, applied []suggestionEdit) error {
+	add := exec.Command("git", append([]string{"add", "--"}, paths...)...)
+	if out, err := add.CombinedOutput(); err != nil {
+		return fmt.Errorf("git add: %v: %s", err, out)
+	}
+
+	msg := message + "\n"
+	seen := map[string]bool{}
+	for _, e := range applied {
+		if seen[e.user] || e.userID == 0 {
+			continue
+		}
+		seen[e.user] = true
+		if len(seen) == 1 {
+			msg += "\n"
+		}
+		msg += fmt.Sprintf("Co-authored-by: %s <%d+%s@users.noreply.github.com>\n", e.user, e.userID, e.user)
+	}
+	cmd := exec.Command("git", "commit", "-m", msg, "--")
+	cmd.Args = append(cmd.Args, paths...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git commit: %v: %s", err, out)
+	}
+	return nil
+}
+
+// pathMatches reports whether path is filter or lies inside the directory filter.
+func pathMatches(path, filter string) bool {
+	filter = strings.TrimSuffix(filter, "/")
+	return path == filter || strings.HasPrefix(path, filter+"/")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// gitRepo creates a repository in a temp dir holding path=content, chdirs into
+// it for the rest of the test and returns the commit SHA.
+func gitRepo(t *testing.T, path, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", path},
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-qm", "init"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Skipf("git %v: %v: %s", args, err, out)
+		}
+	}
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestApplySuggestions(t *testing.T) {
+	sha := gitRepo(t, "f.go", "a\nb\nc\nd\n")
+	edits := []suggestionEdit{
+		{threadID: "T1", path: "f.go", start: 1, line: 1, commitID: sha, lines: []string{"A"}},
+		{threadID: "T2", path: "f.go", start: 3, line: 4, commitID: sha, lines: []string{"CD"}},
+	}
+	applied, err := applySuggestions("f.go", edits, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("applied %d suggestions, want 2", len(applied))
+	}
+	if got := readFile(t, "f.go"); got != "A\nb\nCD\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestApplySuggestions_RefusesChangedLines(t *testing.T) {
+	sha := gitRepo(t, "f.go", "a\nb\n")
+	if err := os.WriteFile("f.go", []byte("a\nB locally\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	edits := []suggestionEdit{
+		{threadID: "T1", path: "f.go", start: 2, line: 2, commitID: sha, lines: []string{"x"}},
+		{threadID: "T2", path: "f.go", start: 1, line: 1, commitID: sha, lines: []string{"y"}},
+	}
+	applied, err := applySuggestions("f.go", edits, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].threadID != "T2" {
+		t.Fatalf("applied = %+v, want only T2", applied)
+	}
+	if got := readFile(t, "f.go"); got != "y\nB locally\n" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// reviewBlock locates one injected review thread inside a file's lines.
+type reviewBlock struct {
+	start      int          // index of the header line
+	body       int          // index of the first comment line
+	sep        int          // index of the separator line; one past the end for comment-style blocks
+	end        int          // index of the trailer line
+	threadIDs  []string     // GraphQL node IDs of the threads shown in the block
+	commentIDs []int64      // database IDs of the rendered comments, in order
+	rootID     int64        // database ID of the block's first comment, 0 if unknown
+	lines      []string     // every line of the block, header to trailer
+	comments   []string     // rendered comment lines, continuation lines included
+	count      int          // number of comments, as given in the header
+	replies    []string     // bodies of REPLY: lines typed by the user
+	resolved   bool         // the user added a RESOLVED line
+	anchor     []string     // original source lines kept by clean
+	suggested  []string     // replacement lines of a suggestion block
+	suggestion bool         // block is a two-sided suggestion conflict
+	removed    []string     // deleted lines a LEFT-side thread was made on
+	deleted    bool         // block sits where removed lines used to be
+	fileLevel  bool         // block holds a thread on the whole file
+	original   []string     // diff3 base section: the lines as the reviewer saw them
+	baseRev    string       // abbreviated commit of the base section
+	note       string       // parenthesised remark at the end of the header
+	style      commentStyle // comment syntax of a --style comments block
+	indent     string       // indentation of a comment-style block
+	tag        string       // REVIEW(...) tag on every line of a comment-style block
+	sum        string       // checksum of the generated lines, "" for older blocks
+}
+
+// Lines the user may add between the comments and the separator of a block.
+const (
+	replyPrefix  = "REPLY:"   // answer the thread (push-replies)
+	resolvedMark = "RESOLVED" // resolve the thread (sync)
+)
+
+// errMergeConflict reports conflict markers that were not written by prconflict.
+var errMergeConflict = errors.New("looks like a git merge conflict")
+
+// parseReviewBlocks finds every review block in src. It only accepts the exact
+// shapes produced by injectThreads, and fails if src also holds conflict
+// markers that do not belong to a review block.
+func parseReviewBlocks(src []string) ([]reviewBlock, error) {
+	var blocks []reviewBlock
+	for i := 0; i < len(src); i++ {
+		line := strings.TrimSuffix(src[i], "\r")
+		if strings.Contains(line, commentTagPrefix) {
+			if b, ok := parseCommentBlock(src, i); ok {
+				blocks = append(blocks, b)
+				i = b.end
+				continue
+			}
+		}
+		// Attributes after the count look like `suggestion thread=PRRT_a,PRRT_b
+		// comments=101,102 (a note)`; older blocks carry `#<root comment ID>`.
+		if n, attrs, ok := markers.parseHeader(line); ok {
+			b := reviewBlock{start: i}
+			if err := b.parseAttrs(attrs); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if err := b.parseBody(src, n); err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, b)
+			i = b.end
+			continue
+		}
+		if isConflictMarker(line) {
+			return nil, fmt.Errorf("line %d: %w", i+1, errMergeConflict)
+		}
+	}
+	return blocks, nil
+}
+
+// parseBody validates the lines after the header, which sits at src[b.start].
+//
+// A thread block holds n comments, each a line followed by its indented or
+// empty continuation lines, optional REPLY: and RESOLVED lines, the
+// separator, the anchored source (the whole commented range) and the trailer.
+// A suggestion block first holds the current source, then the base
+// marker before its comments, and puts the suggested replacement between
+// separator and trailer. A block on deleted lines has an empty anchor and
+// shows the removed lines in a base section before its separator; a
+// diff3 block shows the lines the reviewer saw there instead.
+func (b *reviewBlock) parseBody(src []string, n int) error {
+	i := b.start + 1
+	if b.suggestion {
+		j, err := scanTo(src, i, markers.base)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", b.start+1, err)
+		}
+		b.anchor = src[i:j]
+		i = j + 1
+	}
+
+	b.body = i
+	for k := 0; k < n; k++ {
+		if i >= len(src) {
+			return fmt.Errorf("line %d: truncated review block", b.start+1)
+		}
+		for i++; i < len(src) && isContinuation(src[i]); i++ {
+		}
+	}
+	b.comments, b.count = src[b.body:i], n
+	for ; i < len(src); i++ {
+		line := strings.TrimSuffix(src[i], "\r")
+		if strings.HasPrefix(line, replyPrefix) {
+			b.replies = append(b.replies, strings.TrimSpace(strings.TrimPrefix(line, replyPrefix)))
+		} else if strings.TrimSpace(line) == resolvedMark {
+			b.resolved = true
+		} else {
+			break
+		}
+	}
+	if (b.deleted || b.baseRev != "") && i < len(src) && strings.TrimSuffix(src[i], "\r") == markers.base {
+		j, err := scanTo(src, i+1, markers.separator)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", b.start+1, err)
+		}
+		if b.deleted {
+			b.removed = unescapeCode(src[i+1 : j])
+		} else {
+			b.original = unescapeCode(src[i+1 : j])
+		}
+		i = j
+	}
+	if i >= len(src) || strings.TrimSuffix(src[i], "\r") != markers.separator {
+		return fmt.Errorf("line %d: expected %q after %d comment(s)", i+1, markers.separator, n)
+	}
+	b.sep = i
+
+	j, err := scanTo(src, i+1, markers.trailer)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", b.start+1, err)
+	}
+	if b.suggestion {
+		b.suggested = src[i+1 : j]
+	} else {
+		b.anchor = src[i+1 : j]
+	}
+	b.end = j
+	b.lines = src[b.start : b.end+1]
+	return nil
+}
+
+// scanTo returns the index of the first line at or after i equal to marker,
+// failing on any other conflict marker on the way.
+func scanTo(src []string, i int, marker string) (int, error) {
+	for ; i < len(src); i++ {
+		line := strings.TrimSuffix(src[i], "\r")
+		if line == marker {
+			return i, nil
+		}
+		if isConflictMarker(line) {
+			return 0, fmt.Errorf("unexpected %q before %q", line, marker)
+		}
+	}
+	return 0, fmt.Errorf("missing %q", marker)
+}
+
+// parseAttrs reads the identifiers that follow the comment count in a header.
+func (b *reviewBlock) parseAttrs(attrs string) error {
+	fields := strings.Fields(attrs)
+	for i, f := range fields {
+		if strings.HasPrefix(f, "(") {
+			b.note = strings.TrimSuffix(strings.TrimPrefix(strings.Join(fields[i:], " "), "("), ")")
+			break
+		}
+		key, val, _ := strings.Cut(f, "=")
+		switch {
+		case strings.HasPrefix(f, "#"):
+			id, err := strconv.ParseInt(f[1:], 10, 64)
+			if err != nil {
+				return fmt.Errorf("bad comment ID %q", f)
+			}
+			b.rootID = id
+		case f == "suggestion":
+			b.suggestion = true
+		case f == "deleted":
+			b.deleted = true
+		case f == "file":
+			b.fileLevel = true
+		case key == "base":
+			b.baseRev = val
+		case key == "sum":
+			b.sum = val
+		case key == "thread":
+			b.threadIDs = strings.Split(val, ",")
+		case key == "comments":
+			for _, s := range strings.Split(val, ",") {
+				id, err := strconv.ParseInt(s, 10, 64)
+				if err != nil {
+					return fmt.Errorf("bad comment ID %q", s)
+				}
+				b.commentIDs = append(b.commentIDs, id)
+			}
+		}
+	}
+	if b.rootID == 0 && len(b.commentIDs) > 0 {
+		b.rootID = b.commentIDs[0]
+	}
+	return nil
+}
+
+// isConflictMarker reports whether line opens, splits or closes a git conflict.
+func isConflictMarker(line string) bool {
+	for _, m := range []string{"<<<<<<<", "|||||||", ">>>>>>>"} {
+		if strings.HasPrefix(line, m) && (len(line) == len(m) || line[len(m)] == ' ') {
+			return true
+		}
+	}
+	return false
+}
+
+// stripReviewBlocks removes every review block from src, keeping the anchored
+// source lines, and returns the cleaned lines with the number of blocks removed.
+func stripReviewBlocks(src []string) ([]string, int, error) {
+	blocks, err := parseReviewBlocks(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	return removeBlocks(src, blocks), len(blocks), nil
+}
+
+// stripReplies drops the REPLY: lines from every block in src.
+func stripReplies(src []string, blocks []reviewBlock) []string {
+	out := make([]string, 0, len(src))
+	prev := 0
+	for _, b := range blocks {
+		extra := b.body + len(b.comments)
+		out = append(out, src[prev:extra]...)
+		for i, l := range src[extra:b.sep] {
+			if strings.TrimSuffix(l, "\r") == markers.base {
+				// Source lines in a base section are not replies.
+				out = append(out, src[extra+i:b.sep]...)
+				break
+			}
+			if !strings.HasPrefix(b.text(l), replyPrefix) {
+				out = append(out, l)
+			}
+		}
+		prev = b.sep
+	}
+	return append(out, src[prev:]...)
+}
+
+// removeBlocks replaces the given blocks with their anchored source lines.
+func removeBlocks(src []string, blocks []reviewBlock) []string {
+	out := make([]string, 0, len(src))
+	prev := 0
+	for _, b := range blocks {
+		out = append(out, src[prev:b.start]...)
+		out = append(out, b.anchor...)
+		prev = b.end + 1
+	}
+	return append(out, src[prev:]...)
+}
+
+// forEachInjectedFile walks roots and calls fn with the lines of every regular
+// text file that contains at least one review header. .git directories and
+// REVIEW.md files written by prconflict are skipped.
+func forEachInjectedFile(roots []string, fn func(path string, src []string)) error {
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if d.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if !bytes.Contains(data, []byte(markers.headerPrefix)) && !bytes.Contains(data, []byte(commentTagPrefix)) || bytes.IndexByte(data, 0) >= 0 {
+				return nil // nothing injected, or binary
+			}
+			if isReviewMarkdown(data) {
+				return nil // quotes the PR conversation, holds no blocks
+			}
+			fn(path, strings.Split(string(data), "\n"))
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", root, err)
+		}
+	}
+	return nil
+}
+
+// writeLines replaces path with lines, keeping the file's permissions.
+func writeLines(path string, lines []string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")), info.Mode().Perm())
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReviewBlocks_Replies(t *testing.T) {
+	src := strings.Split("x\n"+
+		"<<<<<<< REVIEW THREAD (1) #42\n"+
+		"2024-01-01 10:00 alice: rename this\n"+
+		"REPLY: done, renamed\n"+
+		"REPLY:   see next commit\n"+
+		"=======\n"+
+		"func renamed() {}\n"+
+		">>>>>>> END REVIEW\n", "\n")
+
+	blocks, err := parseReviewBlocks(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1", len(blocks))
+	}
+	b := blocks[0]
+	if b.rootID != 42 {
+		t.Errorf("rootID = %d, want 42", b.rootID)
+	}
+	if len(b.comments) != 1 || b.comments[0] != "2024-01-01 10:00 alice: rename this" {
+		t.Errorf("comments = %q", b.comments)
+	}
+	want := []string{"done, renamed", "see next commit"}
+	if strings.Join(b.replies, "|") != strings.Join(want, "|") {
+		t.Errorf("replies = %q, want %q", b.replies, want)
+	}
+
+	got := strings.Join(stripReplies(src, blocks), "\n")
+	wantSrc := "x\n<<<<<<< REVIEW THREAD (1) #42\n2024-01-01 10:00 alice: rename this\n" +
+		"=======\nfunc renamed() {}\n>>>>>>> END REVIEW\n"
+	if got != wantSrc {
+		t.Errorf("stripReplies = %q, want %q", got, wantSrc)
+	}
+}
+
+func TestParseReviewBlocks_LegacyHeader(t *testing.T) {
+	src := strings.Split("<<<<<<< REVIEW THREAD (1)\nc\n=======\nx\n>>>>>>> END REVIEW", "\n")
+	blocks, err := parseReviewBlocks(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[0].rootID != 0 {
+		t.Fatalf("unexpected blocks: %+v", blocks)
+	}
+}
+
+func TestParseReviewBlocks_ResolvedMark(t *testing.T) {
+	src := strings.Split("<<<<<<< REVIEW THREAD (1) #7\nc\nREPLY: thanks\nRESOLVED\n=======\nx\n>>>>>>> END REVIEW", "\n")
+	blocks, err := parseReviewBlocks(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || !blocks[0].resolved {
+		t.Fatalf("expected one resolved block, got %+v", blocks)
+	}
+
+	// Posting replies must keep the RESOLVED mark for a later sync.
+	got := strings.Join(stripReplies(src, blocks), "\n")
+	want := "<<<<<<< REVIEW THREAD (1) #7\nc\nRESOLVED\n=======\nx\n>>>>>>> END REVIEW"
+	if got != want {
+		t.Errorf("stripReplies = %q, want %q", got, want)
+	}
+	if got := strings.Join(removeBlocks(src, blocks), "\n"); got != "x" {
+		t.Errorf("removeBlocks = %q, want %q", got, "x")
+	}
+}
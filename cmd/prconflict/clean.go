@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// runClean implements `prconflict clean [paths...]`: it strips every injected
+// review block from the given files or directories (default: current directory).
+// Blocks that prconflict cannot tell it wrote, such as examples in
+// documentation, are reported and left alone.
+func runClean(args []string) {
+	fset := flag.NewFlagSet("clean", flag.ExitOnError)
+	tmpl := addTemplateFlag(fset)
+	dryRun := fset.Bool("dry-run", false, "Report blocks that would be removed without writing files")
+	fset.Parse(args)
+	useTemplate(*tmpl)
+
+	roots := fset.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	recorded := recordedThreads()
+	refused := 0
+	var stripped []reviewBlock
+	err := forEachInjectedFile(roots, func(path string, src []string) {
+		removed, skipped, err := cleanFile(path, src, recorded, *dryRun)
+		if err != nil {
+			log.Printf("%s: %v – left untouched", path, err)
+			refused++
+			return
+		}
+		for _, b := range skipped {
+			log.Printf("%s:%d: block has no matching sum= and its thread was not injected here – left in place", path, b.start+1)
+		}
+		if len(removed) > 0 {
+			fmt.Printf("%s: removed %d review block(s)\n", path, len(removed))
+		}
+		stripped = append(stripped, removed...)
+	})
+	if err != nil {
+		log.Fatalf("clean: %v", err)
+	}
+	if !*dryRun {
+		// Cleaned threads are no longer in the tree; sync must not take their
+		// missing blocks for deletions meant to resolve them.
+		if err := forgetThreads(stripped); err != nil {
+			log.Printf("could not update sync state: %v", err)
+		}
+	}
+	if refused > 0 {
+		os.Exit(1)
+	}
+}
+
+// cleanFile strips review blocks from the lines of path and returns the blocks
+// removed and those skipped. Only blocks whose checksum still matches or whose
+// thread is in recorded (see blockKeys) are removed. Files containing foreign
+// conflict markers or malformed blocks are not modified.
+func cleanFile(path string, src []string, recorded map[string]bool, dry bool) (removed, skipped []reviewBlock, err error) {
+	blocks, err := parseReviewBlocks(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, b := range blocks {
+		if sumMatches(b) || anyKey(recorded, blockKeys(b)) {
+			removed = append(removed, b)
+		} else {
+			skipped = append(skipped, b)
+		}
+	}
+	if len(removed) == 0 || dry {
+		return removed, skipped, nil
+	}
+	return removed, skipped, writeLines(path, removeBlocks(src, removed))
+}
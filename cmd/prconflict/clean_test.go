@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripReviewBlocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		removed int
+		err     error
+	}{
+		{
+			name:    "no blocks",
+			src:     "a\nb\n",
+			want:    "a\nb\n",
+			removed: 0,
+		},
+		{
+			name: "single block keeps anchor",
+			src: "a\n<<<<<<< REVIEW THREAD (2)\n2024-01-01 10:00 alice: fix\n" +
+				"2024-01-01 11:00 bob: agreed\n=======\nb\n>>>>>>> END REVIEW\nc\n",
+			want:    "a\nb\nc\n",
+			removed: 1,
+		},
+		{
+			name: "comment that looks like a separator",
+			src: "<<<<<<< REVIEW THREAD (1)\n=======\n=======\nx\n>>>>>>> END REVIEW\n" +
+				"<<<<<<< REVIEW THREAD (1)\nok\n=======\ny\n>>>>>>> END REVIEW\n",
+			want:    "x\ny\n",
+			removed: 2,
+		},
+		{
+			name: "genuine merge conflict",
+			src:  "<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> main\n",
+			err:  errMergeConflict,
+		},
+		{
+			name: "review block next to merge conflict",
+			src:  "<<<<<<< REVIEW THREAD (1)\nc\n=======\nx\n>>>>>>> END REVIEW\n<<<<<<< HEAD\na\n=======\nb\n>>>>>>> other\n",
+			err:  errMergeConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, n, err := stripReviewBlocks(strings.Split(tt.src, "\n"))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := strings.Join(out, "\n"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if n != tt.removed {
+				t.Errorf("removed %d blocks, want %d", n, tt.removed)
+			}
+		})
+	}
+}
+
+func TestStripReviewBlocks_Malformed(t *testing.T) {
+	src := "<<<<<<< REVIEW THREAD (2)\nonly one comment\n=======\nx\n>>>>>>> END REVIEW\n"
+	if _, _, err := stripReviewBlocks(strings.Split(src, "\n")); err == nil {
+		t.Fatal("expected error for block with wrong comment count")
+	}
+}
+
+func TestCleanFile_RoundTrip(t *testing.T) {
+	orig := "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"
+	path := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(path, []byte(orig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{
+		{line: 4, comments: []commentInfo{{id: 2, user: "bob", body: "use fmt", created: ts}}},
+		{line: 1, comments: []commentInfo{{id: 1, user: "alice", body: "doc?", created: ts}}},
+	}
+	if _, err := injectThreads(path, threads, nil, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, _, err := cleanFile(path, strings.Split(string(data), "\n"), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("removed %d blocks, want 2", len(removed))
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != orig {
+		t.Errorf("clean did not restore file:\n%s", got)
+	}
+}
+
+func TestCleanFile_OnlyOwnBlocks(t *testing.T) {
+	orig := "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n"
+	path := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(path, []byte(orig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{
+		{line: 4, comments: []commentInfo{{id: 2, threadID: "T2", user: "bob", body: "use fmt", created: ts}}},
+		{line: 1, comments: []commentInfo{{id: 1, threadID: "T1", user: "alice", body: "doc?", created: ts}}},
+	}
+	if _, err := injectThreads(path, threads, nil, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	injected := readFile(t, path)
+
+	// The user answers one thread; the lines they are meant to add keep the block ours.
+	answered := strings.Replace(injected, "2024-01-02 03:04 bob: use fmt\n", "2024-01-02 03:04 bob: use fmt\nREPLY: ok\nRESOLVED\n", 1)
+	// A documentation example, an edited block and a block of a recorded thread, none with a valid sum.
+	example := "<<<<<<< REVIEW THREAD (1) thread=T9 comments=9\n2024-01-02 03:04 eve: example\n=======\nx := 1\n>>>>>>> END REVIEW\n"
+	edited := strings.Replace(answered, "alice: doc?", "alice: doc, please?", 1)
+	recorded := "<<<<<<< REVIEW THREAD (1) thread=T8 comments=8\n2024-01-02 03:04 dan: later\n=======\ny := 2\n>>>>>>> END REVIEW\n"
+	// unblock replaces the block whose header contains id by its anchored lines.
+	unblock := func(src, id, anchor string) string {
+		i := strings.Index(src, "<<<<<<< REVIEW THREAD (1) "+id)
+		j := i + strings.Index(src[i:], ">>>>>>> END REVIEW\n") + len(">>>>>>> END REVIEW\n")
+		return src[:i] + anchor + src[j:]
+	}
+	tests := []struct {
+		name     string
+		src      string
+		recorded map[string]bool
+		removed  int
+		want     string
+	}{
+		{"answered", answered, nil, 2, orig},
+		{"example", answered + example, nil, 2, orig + example},
+		{"edited", edited, nil, 1, unblock(edited, "thread=T2", "\tprintln(\"hi\")\n")},
+		{"recorded", answered + recorded, map[string]bool{"T8": true}, 3, orig + "y := 2\n"},
+		{"edited but recorded", edited, map[string]bool{"T1": true}, 2, orig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			removed, skipped, err := cleanFile(path, strings.Split(tt.src, "\n"), tt.recorded, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(removed) != tt.removed {
+				t.Errorf("removed %d blocks, skipped %d; want %d removed", len(removed), len(skipped), tt.removed)
+			}
+			if got := readFile(t, path); got != tt.want {
+				t.Errorf("cleaned file:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// commentStyle is the comment syntax review threads are written in with
+// --style comments. Its zero value stands for git conflict markers.
+type commentStyle struct {
+	open  string // comment leader, e.g. "//"
+	close string // closer including its leading space, "" for line comments
+}
+
+var (
+	slashComment = commentStyle{open: "//"}
+	hashComment  = commentStyle{open: "#"}
+	dashComment  = commentStyle{open: "--"}
+	cComment     = commentStyle{open: "/*", close: " */"}
+	htmlComment  = commentStyle{open: "<!--", close: " -->"}
+)
+
+// commentStyles maps a leader found in a file back to its syntax.
+var commentStyles = map[string]commentStyle{
+	"//": slashComment, "#": hashComment, "--": dashComment, "/*": cComment, "<!--": htmlComment,
+}
+
+// extStyles picks the comment syntax by file extension.
+var extStyles = map[string]commentStyle{
+	".go": slashComment, ".c": slashComment, ".h": slashComment, ".cc": slashComment,
+	".cpp": slashComment, ".hpp": slashComment, ".cs": slashComment, ".java": slashComment,
+	".kt": slashComment, ".kts": slashComment, ".scala": slashComment, ".swift": slashComment,
+	".rs": slashComment, ".js": slashComment, ".jsx": slashComment, ".mjs": slashComment,
+	".cjs": slashComment, ".ts": slashComment, ".tsx": slashComment, ".dart": slashComment,
+	".php": slashComment, ".proto": slashComment, ".zig": slashComment, ".scss": slashComment,
+	".py": hashComment, ".rb": hashComment, ".sh": hashComment, ".bash": hashComment,
+	".zsh": hashComment, ".pl": hashComment, ".r": hashComment, ".yaml": hashComment,
+	".yml": hashComment, ".toml": hashComment, ".tf": hashComment, ".cmake": hashComment,
+	".nix": hashComment, ".ex": hashComment, ".exs": hashComment, ".ps1": hashComment,
+	".sql": dashComment, ".lua": dashComment, ".hs": dashComment, ".elm": dashComment,
+	".css":  cComment,
+	".html": htmlComment, ".htm": htmlComment, ".xml": htmlComment, ".svg": htmlComment,
+	".md": htmlComment, ".vue": htmlComment, ".svelte": htmlComment,
+}
+
+// nameStyles covers files known by name rather than extension.
+var nameStyles = map[string]commentStyle{
+	"Makefile": hashComment, "GNUmakefile": hashComment, "Dockerfile": hashComment,
+	"Containerfile": hashComment, "CMakeLists.txt": hashComment, "BUILD": hashComment,
+	"WORKSPACE": hashComment, ".gitignore": hashComment, ".dockerignore": hashComment,
+}
+
+// commentStyleFor picks the comment syntax for path from its name, or failing
+// that from a shebang on its first line.
+func commentStyleFor(path string, src []string) (commentStyle, bool) {
+	name := filepath.Base(path)
+	if s, ok := nameStyles[name]; ok {
+		return s, true
+	}
+	if s, ok := extStyles[strings.ToLower(filepath.Ext(name))]; ok {
+		return s, true
+	}
+	if len(src) > 0 && strings.HasPrefix(src[0], "#!") {
+		for _, js := range []string{"node", "deno", "bun"} {
+			if strings.Contains(src[0], js) {
+				return slashComment, true
+			}
+		}
+		return hashComment, true
+	}
+	return commentStyle{}, false
+}
+
+// commentTagPrefix appears on every line of a comment-style block.
+const commentTagPrefix = " REVIEW("
+
+// commentHeaderRE matches the first line of a comment-style block:
+// `<indent><leader> REVIEW(<thread IDs>) (<count>)<attributes><closer>`.
+var commentHeaderRE = regexp.MustCompile(`^([ \t]*)(//|#|--|/\*|<!--) REVIEW\(([^()\s]+)\) \((\d+)\)((?: \S+)*?)( \*/| -->)?$`)
+
+// parseCommentBlock reads a comment-style block whose header is src[i]. Lines
+// that merely look like one are ordinary comments, so anything that does not
+// fit exactly is reported as no block at all.
+//
+// The header is followed by n tagged comment lines, then the removed lines of
+// a deleted block (`- `) or the replacement of a suggestion (`+ `), then any
+// REPLY: and RESOLVED lines the user added, tagged or not. The block wraps no
+// source: it sits right above the line it is about.
+func parseCommentBlock(src []string, i int) (reviewBlock, bool) {
+	m := commentHeaderRE.FindStringSubmatch(strings.TrimSuffix(src[i], "\r"))
+	if m == nil {
+		return reviewBlock{}, false
+	}
+	style := commentStyles[m[2]]
+	n, err := strconv.Atoi(m[4])
+	if m[6] != style.close || err != nil {
+		return reviewBlock{}, false
+	}
+	b := reviewBlock{start: i, body: i + 1, style: style, indent: m[1], tag: "REVIEW(" + m[3] + ")"}
+	if err := b.parseAttrs(m[5]); err != nil {
+		return reviewBlock{}, false
+	}
+	if id, ok := strings.CutPrefix(m[3], "#"); ok {
+		if b.rootID, err = strconv.ParseInt(id, 10, 64); err != nil {
+			return reviewBlock{}, false
+		}
+	} else {
+		b.threadIDs = strings.Split(m[3], ",")
+	}
+
+	j := i + 1
+	for k := 0; k < n; k++ {
+		if j >= len(src) {
+			return reviewBlock{}, false
+		}
+		if t, ok := b.inner(src[j]); !ok || !strings.HasPrefix(t, b.tag+" ") {
+			return reviewBlock{}, false
+		}
+		for j++; j < len(src); j++ {
+			t, ok := b.inner(src[j])
+			if !ok || t != b.tag && !strings.HasPrefix(t, b.tag+" "+continuationIndent) {
+				break
+			}
+		}
+	}
+	b.comments, b.count = src[i+1:j], n
+lines:
+	for ; j < len(src); j++ {
+		t, ok := b.inner(src[j])
+		if !ok {
+			break
+		}
+		rest, tagged := strings.CutPrefix(t, b.tag+" ")
+		switch {
+		case tagged && b.deleted && (rest == "-" || strings.HasPrefix(rest, "- ")):
+			b.removed = append(b.removed, strings.TrimPrefix(rest[1:], " "))
+		case tagged && b.suggestion && (rest == "+" || strings.HasPrefix(rest, "+ ")):
+			b.suggested = append(b.suggested, strings.TrimPrefix(rest[1:], " "))
+		case strings.HasPrefix(rest, replyPrefix):
+			b.replies = append(b.replies, strings.TrimSpace(strings.TrimPrefix(rest, replyPrefix)))
+		case strings.TrimSpace(rest) == resolvedMark:
+			b.resolved = true
+		default:
+			break lines
+		}
+	}
+	b.sep = j
+	b.end = j - 1
+	b.lines = src[b.start:j]
+	return b, true
+}
+
+// inner returns what a line of comment-style block b says between the
+// comment leader and closer, whatever its indentation.
+func (b *reviewBlock) inner(line string) (string, bool) {
+	l := strings.TrimLeft(strings.TrimSuffix(line, "\r"), " \t")
+	open := b.style.open + " "
+	if !strings.HasPrefix(l, open) || !strings.HasSuffix(l[len(open):], b.style.close) {
+		return "", false
+	}
+	return l[len(open) : len(l)-len(b.style.close)], true
+}
+
+// text returns line as the user wrote it inside block b, without the comment
+// syntax and tag of a comment-style block.
+func (b *reviewBlock) text(line string) string {
+	if b.style.open == "" {
+		return line
+	}
+	t, _ := b.inner(line)
+	return strings.TrimPrefix(t, b.tag+" ")
+}
+
+// commentTag names the threads of cs as REVIEW(<thread IDs>), falling back to
+// the first comment's ID like older conflict headers.
+func commentTag(cs []commentInfo) string {
+	var threads []string
+	seen := map[string]bool{}
+	for _, c := range cs {
+		if c.threadID != "" && !seen[c.threadID] {
+			seen[c.threadID] = true
+			threads = append(threads, c.threadID)
+		}
+	}
+	if len(threads) > 0 {
+		return "REVIEW(" + strings.Join(threads, ",") + ")"
+	}
+	var root int64
+	if len(cs) > 0 {
+		root = cs[0].id
+	}
+	return fmt.Sprintf("REVIEW(#%d)", root)
+}
+
+// buildCommentBlock renders th as comments in style, indented like the line
+// they precede, with every line tagged so clean and sync can find it again.
+// It carries the same information as buildBlock: suggested replacements and
+// removed lines are listed as `+ ` and `- ` lines.
+func buildCommentBlock(th lineThread, style commentStyle, indent, tag string, suggest bool) []string {
+	cs := th.comments
+	deleted := len(th.removed) > 0
+	suggested, isSuggestion := threadSuggestion(cs)
+	isSuggestion = isSuggestion && suggest && !deleted && !th.file
+	attrs := ""
+	switch {
+	case isSuggestion:
+		attrs = " suggestion"
+	case deleted:
+		attrs = " deleted"
+	case th.file:
+		attrs = " file"
+	}
+	var ids []string
+	for _, c := range cs {
+		if c.id != 0 {
+			ids = append(ids, strconv.FormatInt(c.id, 10))
+		}
+	}
+	if len(ids) > 0 {
+		attrs += " comments=" + strings.Join(ids, ",")
+	}
+	line := func(text string) string {
+		return indent + style.open + " " + style.escape(strings.TrimRight(text, " ")) + style.close
+	}
+	var body []string
+	for _, c := range cs {
+		for _, l := range markers.comment(c) {
+			body = append(body, line(tag+" "+l))
+		}
+	}
+	if deleted {
+		for _, l := range th.removed {
+			body = append(body, line(tag+" - "+l))
+		}
+	}
+	if isSuggestion {
+		for _, l := range suggested {
+			body = append(body, line(tag+" + "+neutralize(l)))
+		}
+	}
+	attrs += " sum=" + blockSum(body)
+	if th.note != "" {
+		attrs += " (" + th.note + ")"
+	}
+	return append([]string{line(fmt.Sprintf("%s (%d)%s", tag, len(cs), attrs))}, body...)
+}
+
+// escape keeps text from ending a block comment early.
+func (s commentStyle) escape(text string) string {
+	switch s.open {
+	case "/*":
+		return strings.ReplaceAll(text, "*/", "* /")
+	case "<!--":
+		for strings.Contains(text, "--") {
+			text = strings.ReplaceAll(text, "--", "- -")
+		}
+	}
+	return text
+}
+
+// leadingSpace returns the indentation of line.
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
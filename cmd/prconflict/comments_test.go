@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInjectThreads_CommentStyle(t *testing.T) {
+	orig := "func f() {\n\tx := 1\n\ty := 2\n\treturn x + y\n}\n"
+	path := writeTemp(t, orig)
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{
+		{line: 3, start: 2, comments: []commentInfo{{
+			id: 5, threadID: "T5", user: "alice", created: ts,
+			body: "Combine these:\n```suggestion\n\tx, y := 1, 2\n```",
+		}}},
+		{line: 4, comments: []commentInfo{{id: 6, threadID: "T6", user: "bob", body: "ok?", created: ts}}},
+	}
+	known := &threadSet{open: map[string]bool{"T5": true, "T6": true}}
+
+	if _, err := injectThreads(path, threads, known, "comments", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	want := "func f() {\n" +
+		"\t// REVIEW(T5) (1) suggestion comments=5 sum=02deba3d\n" +
+		"\t// REVIEW(T5) 2024-01-02 03:04 alice: Combine these:\n" +
+		"\t// REVIEW(T5)     [suggestion]\n" +
+		"\t// REVIEW(T5) + \tx, y := 1, 2\n" +
+		"\tx := 1\n\ty := 2\n" +
+		"\t// REVIEW(T6) (1) comments=6 sum=922c9609\n" +
+		"\t// REVIEW(T6) 2024-01-02 03:04 bob: ok?\n" +
+		"\treturn x + y\n}\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := injectThreads(path, threads, known, "comments", false); err != nil {
+		t.Fatal(err)
+	}
+	if again := readFile(t, path); again != got {
+		t.Errorf("second run changed the file:\n%s", again)
+	}
+
+	// A reply typed below the comments is picked up and survives reruns.
+	replied := strings.Replace(got, "bob: ok?\n", "bob: ok?\n\t// REPLY: yes\n", 1)
+	blocks, err := parseReviewBlocks(strings.Split(replied, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || len(blocks[1].replies) != 1 || blocks[1].replies[0] != "yes" {
+		t.Fatalf("blocks: %+v", blocks)
+	}
+	if s := strings.Join(blocks[0].suggested, "\n"); s != "\tx, y := 1, 2" {
+		t.Errorf("suggested = %q", s)
+	}
+	if s := strings.Join(stripReplies(strings.Split(replied, "\n"), blocks), "\n"); s != got {
+		t.Errorf("stripReplies:\n%s", s)
+	}
+
+	// The reply does not hide from clean that prconflict wrote the block.
+	removed, _, err := cleanFile(path, strings.Split(replied, "\n"), nil, false)
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("cleanFile: %d blocks, %v", len(removed), err)
+	}
+	if s := readFile(t, path); s != orig {
+		t.Errorf("clean:\n%s", s)
+	}
+}
+
+func TestCommentStyle(t *testing.T) {
+	tests := []struct {
+		path, first string
+		want        commentStyle
+		ok          bool
+	}{
+		{"a/main.go", "", slashComment, true},
+		{"x.PY", "", hashComment, true},
+		{"Makefile", "", hashComment, true},
+		{"q.sql", "", dashComment, true},
+		{"s.css", "", cComment, true},
+		{"README.md", "", htmlComment, true},
+		{"bin/tool", "#!/usr/bin/env node", slashComment, true},
+		{"bin/tool", "#!/bin/sh", hashComment, true},
+		{"data.bin", "", commentStyle{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := commentStyleFor(tt.path, []string{tt.first})
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("commentStyleFor(%q) = %+v, %v", tt.path, got, ok)
+		}
+	}
+
+	// Block comments cannot be closed early by what they quote.
+	th := lineThread{comments: []commentInfo{{id: 1, threadID: "T1", user: "u", body: "drop */ and --> here"}}}
+	for _, s := range []commentStyle{cComment, htmlComment} {
+		lines := buildCommentBlock(th, s, "  ", commentTag(th.comments), true)
+		src := append(lines, "  body {}")
+		blocks, err := parseReviewBlocks(src)
+		if err != nil || len(blocks) != 1 || blocks[0].end != len(lines)-1 {
+			t.Fatalf("%s: %v %+v\n%s", s.open, err, blocks, strings.Join(lines, "\n"))
+		}
+		if blockEdited(blocks[0], map[int64]commentInfo{1: th.comments[0]}) {
+			t.Errorf("%s: block reads as edited:\n%s", s.open, strings.Join(lines, "\n"))
+		}
+		for _, l := range lines {
+			if strings.Count(l, strings.TrimSpace(s.close)) != 1 {
+				t.Errorf("%s: comment closed early: %s", s.open, l)
+			}
+		}
+	}
+}
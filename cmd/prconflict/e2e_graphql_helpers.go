@@ -123,3 +123,198 @@ func (r *GraphQLResolver) ResolveCommentThread(ctx context.Context, owner, repo
 
 	return r.ResolveThread(ctx, threadID)
 }
+
+// AddPullRequestReviewMutation starts a pending review on a pull request
+type AddPullRequestReviewMutation struct {
+	AddPullRequestReview struct {
+		PullRequestReview struct {
+			ID githubv4.String
+		}
+	} `graphql:"addPullRequestReview(input: $input)"`
+}
+
+// AddThreadReplyMutation adds a reply to an existing review thread
+type AddThreadReplyMutation struct {
+	AddPullRequestReviewThreadReply struct {
+		Comment struct {
+			DatabaseID githubv4.Int `graphql:"databaseId"`
+		}
+	} `graphql:"addPullRequestReviewThreadReply(input: $input)"`
+}
+
+// SubmitPullRequestReviewMutation submits a pending review
+type SubmitPullRequestReviewMutation struct {
+	SubmitPullRequestReview struct {
+		PullRequestReview struct {
+			State githubv4.PullRequestReviewState
+		}
+	} `graphql:"submitPullRequestReview(input: $input)"`
+}
+
+// DeletePullRequestReviewMutation deletes a pending review with its comments
+type DeletePullRequestReviewMutation struct {
+	DeletePullRequestReview struct {
+		PullRequestReview struct {
+			ID githubv4.String
+		}
+	} `graphql:"deletePullRequestReview(input: $input)"`
+}
+
+// GetPullRequestID returns the node ID of a pull request
+func (r *GraphQLResolver) GetPullRequestID(ctx context.Context, owner, repo string, prNumber int) (string, error) {
+	var q struct {
+		Repository struct {
+			PullRequest struct {
+				ID githubv4.String
+			} `graphql:"pullRequest(number: $pr)"`
+		} `graphql:"repository(owner: $owner, name: $name)"`
+	}
+	vars := map[string]interface{}{
+		"owner": githubv4.String(owner),
+		"name":  githubv4.String(repo),
+		"pr":    githubv4.Int(prNumber),
+	}
+
+	if err := r.client.Query(ctx, &q, vars); err != nil {
+		return "", fmt.Errorf("failed to query pull request ID: %w", err)
+	}
+	return string(q.Repository.PullRequest.ID), nil
+}
+
+// GetThreadIDsByComment maps every review comment database ID on a pull request to its thread ID
+func (r *GraphQLResolver) GetThreadIDsByComment(ctx context.Context, owner, repo string, prNumber int) (map[int64]string, error) {
+	var q struct {
+		Repository struct {
+			PullRequest struct {
+				ReviewThreads struct {
+					PageInfo struct {
+						HasNextPage githubv4.Boolean
+						EndCursor   githubv4.String
+					}
+					Nodes []struct {
+						ID       githubv4.String
+						Comments struct {
+							Nodes []struct {
+								DatabaseID githubv4.Int `graphql:"databaseId"`
+							}
+						} `graphql:"comments(first: 100)"`
+					}
+				} `graphql:"reviewThreads(first: 100, after: $cursor)"`
+			} `graphql:"pullRequest(number: $pr)"`
+		} `graphql:"repository(owner: $owner, name: $name)"`
+	}
+	vars := map[string]interface{}{
+		"owner":  githubv4.String(owner),
+		"name":   githubv4.String(repo),
+		"pr":     githubv4.Int(prNumber),
+		"cursor": (*githubv4.String)(nil),
+	}
+
+	threads := make(map[int64]string)
+	for {
+		if err := r.client.Query(ctx, &q, vars); err != nil {
+			return nil, fmt.Errorf("failed to query review threads: %w", err)
+		}
+		for _, th := range q.Repository.PullRequest.ReviewThreads.Nodes {
+			for _, c := range th.Comments.Nodes {
+				threads[int64(c.DatabaseID)] = string(th.ID)
+			}
+		}
+		if !bool(q.Repository.PullRequest.ReviewThreads.PageInfo.HasNextPage) {
+			break
+		}
+		vars["cursor"] = githubv4.NewString(q.Repository.PullRequest.ReviewThreads.PageInfo.EndCursor)
+	}
+	return threads, nil
+}
+
+// StartReview creates a pending review and returns its ID
+func (r *GraphQLResolver) StartReview(ctx context.Context, pullRequestID string) (string, error) {
+	var m AddPullRequestReviewMutation
+	input := githubv4.AddPullRequestReviewInput{
+		PullRequestID: githubv4.String(pullRequestID),
+	}
+
+	if err := r.client.Mutate(ctx, &m, input, nil); err != nil {
+		return "", fmt.Errorf("failed to start review: %w", err)
+	}
+	return string(m.AddPullRequestReview.PullRequestReview.ID), nil
+}
+
+// GetPendingReviewID returns the ID of the viewer's pending review on a pull request,
+// or "" if there is none. GitHub allows one pending review per user and pull request.
+func (r *GraphQLResolver) GetPendingReviewID(ctx context.Context, owner, repo string, prNumber int) (string, error) {
+	var q struct {
+		Repository struct {
+			PullRequest struct {
+				Reviews struct {
+					Nodes []struct {
+						ID              githubv4.String
+						ViewerDidAuthor githubv4.Boolean
+					}
+				} `graphql:"reviews(states: PENDING, first: 10)"`
+			} `graphql:"pullRequest(number: $pr)"`
+		} `graphql:"repository(owner: $owner, name: $name)"`
+	}
+	vars := map[string]interface{}{
+		"owner": githubv4.String(owner),
+		"name":  githubv4.String(repo),
+		"pr":    githubv4.Int(prNumber),
+	}
+
+	if err := r.client.Query(ctx, &q, vars); err != nil {
+		return "", fmt.Errorf("failed to query pending reviews: %w", err)
+	}
+	for _, rv := range q.Repository.PullRequest.Reviews.Nodes {
+		if rv.ViewerDidAuthor {
+			return string(rv.ID), nil
+		}
+	}
+	return "", nil
+}
+
+// DeleteReview deletes a pending review together with the comments added to it
+func (r *GraphQLResolver) DeleteReview(ctx context.Context, reviewID string) error {
+	var m DeletePullRequestReviewMutation
+	input := githubv4.DeletePullRequestReviewInput{
+		PullRequestReviewID: githubv4.String(reviewID),
+	}
+
+	if err := r.client.Mutate(ctx, &m, input, nil); err != nil {
+		return fmt.Errorf("failed to delete review %s: %w", reviewID, err)
+	}
+	return nil
+}
+
+// ReplyToThread adds a reply to a thread, attached to the given pending review
+func (r *GraphQLResolver) ReplyToThread(ctx context.Context, reviewID, threadID, body string) error {
+	var m AddThreadReplyMutation
+	input := githubv4.AddPullRequestReviewThreadReplyInput{
+		PullRequestReviewThreadID: githubv4.String(threadID),
+		Body:                      githubv4.String(body),
+	}
+	if reviewID != "" {
+		var id githubv4.ID = githubv4.String(reviewID)
+		input.PullRequestReviewID = &id
+	}
+
+	if err := r.client.Mutate(ctx, &m, input, nil); err != nil {
+		return fmt.Errorf("failed to reply to thread %s: %w", threadID, err)
+	}
+	return nil
+}
+
+// SubmitReview submits a pending review as a plain comment review
+func (r *GraphQLResolver) SubmitReview(ctx context.Context, reviewID string) error {
+	var m SubmitPullRequestReviewMutation
+	var id githubv4.ID = githubv4.String(reviewID)
+	input := githubv4.SubmitPullRequestReviewInput{
+		Event:               githubv4.PullRequestReviewEventComment,
+		PullRequestReviewID: &id,
+	}
+
+	if err := r.client.Mutate(ctx, &m, input, nil); err != nil {
+		return fmt.Errorf("failed to submit review %s: %w", reviewID, err)
+	}
+	return nil
+}
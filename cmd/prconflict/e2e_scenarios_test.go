@@ -432,7 +432,7 @@ func (s *UserService) CreateUser(name string) error {
 	}
 }
 
-func TestE2E_CommentSanitization(t *testing.T) {
+func TestE2E_CommentRendering(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping E2E test in short mode")
 	}
@@ -448,7 +448,7 @@ func TestE2E_CommentSanitization(t *testing.T) {
 	}()
 
 	scenario := TestScenario{
-		Name: "Comments with special characters requiring sanitization",
+		Name: "Comments with special characters rendered as written",
 		InitialFiles: map[string]string{
 			"parser.go": `package main
 
@@ -484,9 +484,9 @@ func parseConfig() map[string]string {
 				Line: 8,
 				Contains: []string{
 					"<<<<<<< REVIEW THREAD (1)",
-					"This has multiple issues: 1. Error handling missing 2. Hard-coded JSON 3. No validation", // Should be sanitized (newlines become spaces)
-					"See: https:example.combest-practices",                                                    // URLs should be preserved but / removed
-					"Bold text and italic formatting",                                                         // Markdown should be sanitized (* removed)
+					"This has multiple issues:\n    1. Error handling missing\n    2. Hard-coded JSON\n    3. No validation\n\n", // Lines kept, indented under the author
+					"    See: https://example.com/best-practices\n",                                                              // URLs kept intact
+					"    **Bold text** and *italic* formatting\n",                                                                // Markdown kept intact
 					"=======",
 					">>>>>>> END REVIEW",
 				},
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// On public repositories anyone can leave a review comment, and its text ends
+// up in source files, REVIEW.md and editors. Before it gets there, characters
+// that could hide code or fake block boundaries are spelled out instead.
+
+// Kinds of content neutralize changes, in the order they are reported.
+const (
+	kindControl   = "control character"
+	kindSeparator = "line separator"
+	kindBidi      = "bidi control"
+	kindInvalid   = "invalid UTF-8 byte"
+	kindMarker    = "marker line"
+)
+
+// unsafeKind classifies a rune decoded with the given size, or returns "" for
+// one that can be written as it is. Tab and newline are safe; a carriage
+// return only as part of "\r\n", which callers turn into "\n" first.
+func unsafeKind(r rune, size int) string {
+	switch {
+	case r == utf8.RuneError && size == 1:
+		return kindInvalid
+	case r == '\t' || r == '\n':
+		return ""
+	case r < 0x20 || r >= 0x7f && r <= 0x9f:
+		// C0 and C1 controls: ANSI escapes, NUL, lone carriage returns, NEL.
+		return kindControl
+	case r == '\u2028' || r == '\u2029':
+		return kindSeparator
+	case r == '\u061c' || r == '\u200e' || r == '\u200f' ||
+		r >= '\u202a' && r <= '\u202e' || r >= '\u2066' && r <= '\u2069':
+		// Trojan Source: reorders how the surrounding text is displayed.
+		return kindBidi
+	}
+	return ""
+}
+
+// neutralize spells out every unsafe character of s as <U+XXXX>, so that it
+// shows in the file instead of taking effect. Invalid bytes become U+FFFD.
+func neutralize(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch unsafeKind(r, size) {
+		case "":
+			b.WriteString(s[i : i+size])
+		case kindInvalid:
+			b.WriteRune(utf8.RuneError)
+		default:
+			fmt.Fprintf(&b, "<U+%04X>", r)
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// neutralizeCode neutralizes lines of suggested code and escapes those that
+// would read as block markers with a backslash.
+func neutralizeCode(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		out[i] = neutralize(l)
+		if markers.forged(out[i]) {
+			out[i] = `\` + out[i]
+		}
+	}
+	return out
+}
+
+// escapeCode escapes source lines shown in a base section that would read as
+// block markers with a backslash; unescapeCode undoes it. A line that already
+// has backslashes before such a marker gets one more, so the escape round-trips.
+func escapeCode(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if markerLike(l) {
+			l = `\` + l
+		}
+		out[i] = l
+	}
+	return out
+}
+
+func unescapeCode(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if strings.HasPrefix(l, `\`) && markerLike(l) {
+			l = l[1:]
+		}
+		out[i] = l
+	}
+	return out
+}
+
+// markerLike reports whether line reads as a block marker once its leading
+// backslashes are removed.
+func markerLike(line string) bool {
+	return markers.forged(strings.TrimLeft(line, `\`))
+}
+
+// hostileContent describes what would be neutralized in text before writing
+// it into a file, e.g. "2 bidi control(s)", or nothing for ordinary text.
+// Lines that look like block markers are counted too: they are indented or
+// escaped wherever they are written.
+func hostileContent(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	counts := map[string]int{}
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if kind := unsafeKind(r, size); kind != "" {
+			counts[kind]++
+		}
+		i += size
+	}
+	for _, l := range strings.Split(text, "\n") {
+		if markers.forged(strings.TrimSpace(l)) {
+			counts[kindMarker]++
+		}
+	}
+	var out []string
+	for _, kind := range []string{kindControl, kindSeparator, kindBidi, kindInvalid, kindMarker} {
+		if n := counts[kind]; n > 0 {
+			out = append(out, fmt.Sprintf("%d %s(s)", n, kind))
+		}
+	}
+	return out
+}
+
+// reportHostile logs every comment in files whose text gets neutralized.
+func reportHostile(files map[string]map[threadKey]*lineThread) {
+	var reports []string
+	for path, threads := range files {
+		for _, th := range threads {
+			for _, c := range th.comments {
+				if hostile := hostileContent(c.body); len(hostile) > 0 {
+					reports = append(reports, fmt.Sprintf("%s:%d – comment %d by %s: neutralized %s",
+						path, th.line, c.id, neutralize(c.user), strings.Join(hostile, ", ")))
+				}
+			}
+		}
+	}
+	sort.Strings(reports)
+	for _, r := range reports {
+		log.Print(r)
+	}
+}
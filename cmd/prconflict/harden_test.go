@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNeutralize(t *testing.T) {
+	tests := []struct {
+		in, want string
+		hostile  []string
+	}{
+		{"plain text\n\tindented", "plain text\n\tindented", nil},
+		{"a\r\nb", "a\nb", nil},
+		{"\x1b[31mred\x1b[0m", "<U+001B>[31mred<U+001B>[0m", []string{"2 control character(s)"}},
+		{"nul\x00 and lone\rcr", "nul<U+0000> and lone<U+000D>cr", []string{"2 control character(s)"}},
+		{"isAdmin\u202e \u2066// check later\u2069\u2066", "isAdmin<U+202E> <U+2066>// check later<U+2069><U+2066>", []string{"4 bidi control(s)"}},
+		{"one\u2028two", "one<U+2028>two", []string{"1 line separator(s)"}},
+		{"bad \xff byte", "bad \ufffd byte", []string{"1 invalid UTF-8 byte(s)"}},
+		{"fine\n=======\n  >>>>>>> END REVIEW", "fine\n=======\n  >>>>>>> END REVIEW", []string{"2 marker line(s)"}},
+	}
+	for _, tt := range tests {
+		if got := neutralize(tt.in); got != tt.want {
+			t.Errorf("neutralize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if got := hostileContent(tt.in); strings.Join(got, ", ") != strings.Join(tt.hostile, ", ") {
+			t.Errorf("hostileContent(%q) = %q, want %q", tt.in, got, tt.hostile)
+		}
+	}
+}
+
+func TestInjectThreads_HostileComment(t *testing.T) {
+	orig := "func f() {\n\tif admin {\n\t\treturn\n\t}\n}\n"
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	for _, style := range []string{"conflict", "comments"} {
+		t.Run(style, func(t *testing.T) {
+			path := writeTemp(t, orig)
+			threads := []lineThread{
+				{line: 3, comments: []commentInfo{{
+					id: 1, threadID: "T1", user: "mallory", created: ts,
+					body: "=======\n>>>>>>> END REVIEW\n<<<<<<< REVIEW THREAD (9) thread=T9\n\x1b[2K\x00 */ -->",
+				}}},
+				{line: 2, comments: []commentInfo{{
+					id: 2, threadID: "T2", user: "mallory", created: ts,
+					body: "```suggestion\n\tif admin\u202e { \u2066// not admin\u2069\u2066\n>>>>>>> END REVIEW\n```",
+				}}},
+			}
+			known := &threadSet{open: map[string]bool{"T1": true, "T2": true}}
+			if _, err := injectThreads(path, threads, known, style, false); err != nil {
+				t.Fatal(err)
+			}
+			got := readFile(t, path)
+			if strings.ContainsAny(got, "\x1b\x00\u202e\u2066") {
+				t.Errorf("unsafe characters written:\n%q", got)
+			}
+			blocks, err := parseReviewBlocks(strings.Split(got, "\n"))
+			if err != nil || len(blocks) != 2 {
+				t.Fatalf("blocks: %+v, %v\n%s", blocks, err, got)
+			}
+			if _, _, err := cleanFile(path, strings.Split(got, "\n"), nil, false); err != nil {
+				t.Fatal(err)
+			}
+			if after := readFile(t, path); after != orig {
+				t.Errorf("clean did not restore the file:\n%s", after)
+			}
+		})
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// threadSet describes what GitHub currently knows about the PR's review threads.
+type threadSet struct {
+	open      map[string]bool       // unresolved thread IDs
+	comments  map[int64]commentInfo // every fetched review comment, resolved or not
+	untrusted map[string]bool       // open threads the trust policy excludes entirely
+}
+
+// threadIndex looks up what this run knows about the threads of one file.
+type threadIndex struct {
+	open      map[string]bool
+	untrusted map[string]bool
+	byThread  map[string][]commentInfo
+	byID      map[int64]commentInfo
+	notes     map[string]string
+	originals map[string]*originalCode
+}
+
+// placement positions one review block on a file with all review blocks removed.
+type placement struct {
+	idx    int          // index of the first anchored line
+	n      int          // number of anchored lines
+	thread lineThread   // thread to render when raw is nil
+	raw    []string     // block kept exactly as found in the file
+	plain  bool         // merged from several ranges; suggestions render as comments
+	style  commentStyle // comment syntax to render in, zero for conflict markers
+	indent string       // indentation of a comment-style block
+}
+
+// injectThreads writes review conflict blocks into a file and returns the threads
+// present in it afterwards. Blocks left by an earlier run are updated in place:
+// new replies are added, threads no longer open are dropped, and blocks the
+// user has edited are left alone. With style "comments", new threads are
+// written as comments in the file's language above the lines they are about,
+// falling back to conflict blocks where the language is unknown.
+func injectThreads(path string, threads []lineThread, known *threadSet, style string, dry bool) ([]lineThread, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var src []string
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	for sc.Scan() {
+		src = append(src, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	blocks, err := parseReviewBlocks(src)
+	if err != nil {
+		return nil, err
+	}
+	// GitHub line numbers refer to the file without our blocks.
+	base := removeBlocks(src, blocks)
+	var cstyle commentStyle
+	if style == "comments" {
+		var ok bool
+		if cstyle, ok = commentStyleFor(path, base); !ok {
+			log.Printf("%s – no comment syntax known for this file, using conflict markers", path)
+		}
+	}
+
+	ix := threadIndex{
+		byThread:  map[string][]commentInfo{},
+		byID:      map[int64]commentInfo{},
+		notes:     map[string]string{},
+		originals: map[string]*originalCode{},
+	}
+	if known != nil {
+		ix.open, ix.untrusted = known.open, known.untrusted
+		for id, c := range known.comments {
+			ix.byID[id] = c
+		}
+	}
+	for _, th := range threads {
+		for _, c := range th.comments {
+			ix.byThread[c.threadID] = append(ix.byThread[c.threadID], c)
+			ix.byID[c.id] = c
+			if th.note != "" {
+				ix.notes[c.threadID] = th.note
+			}
+			if th.original != nil {
+				ix.originals[c.threadID] = th.original
+			}
+		}
+	}
+
+	var places []*placement
+	handled := map[string]bool{}
+	removed := 0
+	for _, b := range blocks {
+		idx := b.start - removed
+		removed += b.end - b.start + 1 - len(b.anchor)
+		if p := updateBlock(path, b, idx, ix, handled); p != nil {
+			places = append(places, p)
+		}
+	}
+
+	for _, th := range threads {
+		var fresh []commentInfo
+		for _, c := range th.comments {
+			if !handled[c.threadID] {
+				fresh = append(fresh, c)
+			}
+		}
+		if len(fresh) == 0 {
+			continue
+		}
+		idx, n := th.line-1, 1
+		switch {
+		case th.file:
+			idx, n = preambleEnd(base), 0
+		case len(th.removed) > 0:
+			n = 0 // deleted lines sit between source lines, possibly after the last
+		}
+		if idx < 0 || idx+n > len(base) {
+			log.Printf("%s:%d – line vanished, skipping", path, th.line)
+			continue
+		}
+		// A range comment wraps every line the reviewer selected.
+		if n == 1 && th.start > 0 && th.start < th.line {
+			idx, n = th.start-1, th.line-th.start+1
+		}
+		p := &placement{idx: idx, n: n, thread: lineThread{line: th.line, start: th.start, note: th.note, removed: th.removed, file: th.file, original: th.original, comments: fresh}, style: cstyle}
+		if !th.file && idx < len(base) {
+			p.indent = leadingSpace(base[idx])
+		}
+		places = append(places, p)
+	}
+
+	var out []string
+	var present []lineThread
+	prev := 0
+	for _, p := range mergeOverlaps(path, places) {
+		out = append(out, base[prev:p.idx]...)
+		switch {
+		case p.raw != nil:
+			out = append(out, p.raw...)
+		case p.style.open != "":
+			// Comments go above the source they are about, which stays as is.
+			out = append(out, buildCommentBlock(p.thread, p.style, p.indent, commentTag(p.thread.comments), !p.plain)...)
+			out = append(out, base[p.idx:p.idx+p.n]...)
+		default:
+			out = append(out, buildBlock(p.thread, base[p.idx:p.idx+p.n], !p.plain)...)
+		}
+		prev = p.idx + p.n
+		present = append(present, p.thread)
+	}
+	out = append(out, base[prev:]...)
+
+	if dry {
+		fmt.Print(unifiedDiff(path, string(data), strings.Join(out, "\n")+"\n", false))
+		return present, nil
+	}
+
+	if equalLines(out, src) {
+		return present, nil
+	}
+	return present, os.WriteFile(path, []byte(strings.Join(out, "\n")+"\n"), 0644)
+}
+
+// mergeOverlaps orders placements by position and merges overlapping ones,
+// since conflict blocks cannot nest: the merged block wraps the union of the
+// ranges and lists all their comments in order. A block kept verbatim cannot
+// absorb others, so whatever overlaps it is skipped. Blocks on deleted lines
+// wrap nothing; one falling inside a range is shown just before it.
+func mergeOverlaps(path string, places []*placement) []*placement {
+	sort.SliceStable(places, func(i, j int) bool {
+		if places[i].idx != places[j].idx {
+			return places[i].idx < places[j].idx
+		}
+		return places[i].n == 0 && places[j].n > 0
+	})
+	var out []*placement
+	for _, p := range places {
+		if len(out) == 0 || p.idx >= out[len(out)-1].idx+out[len(out)-1].n {
+			out = append(out, p)
+			continue
+		}
+		last := out[len(out)-1]
+		switch {
+		case p.n == 0:
+			p.idx = last.idx
+			out = append(out[:len(out)-1], p, last)
+		case last.raw == nil && p.raw == nil:
+			end := max(last.idx+last.n, p.idx+p.n)
+			if p.idx != last.idx || end != last.idx+last.n || p.n != last.n {
+				// A suggestion only fits the exact range it replaces, and so does a base section.
+				last.plain = true
+				last.thread.original = nil
+			} else if last.thread.original == nil {
+				last.thread.original = p.thread.original
+			}
+			last.n = end - last.idx
+			last.plain = last.plain || p.plain
+			last.thread.line, last.thread.start = end, last.idx+1
+			last.thread.comments = append(last.thread.comments, p.thread.comments...)
+			sortComments(last.thread.comments)
+			if last.thread.note == "" {
+				last.thread.note = p.thread.note
+			}
+		case p.raw != nil && last.raw == nil:
+			log.Printf("%s:%d – overlaps a block edited locally, skipping", path, last.thread.line)
+			out[len(out)-1] = p
+		default:
+			log.Printf("%s:%d – overlaps a block edited locally, skipping", path, p.thread.line)
+		}
+	}
+	return out
+}
+
+// updateBlock decides what happens to a block found in the file, whose anchor
+// starts at idx in the block-free file. It returns nil if the block should be
+// removed and marks the threads it covers as handled.
+func updateBlock(path string, b reviewBlock, idx int, ix threadIndex, handled map[string]bool) *placement {
+	ids := b.threadIDs
+	if len(ids) == 0 && b.rootID != 0 {
+		if c, ok := ix.byID[b.rootID]; ok {
+			ids = []string{c.threadID}
+		}
+	}
+	for _, id := range ids {
+		handled[id] = true
+	}
+
+	p := &placement{idx: idx, n: len(b.anchor), thread: lineThread{line: idx + len(b.anchor)}, style: b.style, indent: b.indent}
+	if len(b.anchor) > 1 {
+		p.thread.start = idx + 1
+	}
+	if b.style.open != "" {
+		p.thread.line = idx + 1 // the line below the comments
+	}
+	if b.deleted {
+		p.thread.line, p.thread.removed = idx+1, b.removed
+	}
+	if b.fileLevel {
+		p.thread.line, p.thread.file = 0, true
+	}
+	if b.baseRev != "" {
+		p.thread.original = &originalCode{rev: b.baseRev, lines: b.original}
+	}
+	// Blocks merged from several threads stay plain, as when first written.
+	p.plain = len(ids) > 1 && !b.suggestion
+	for _, id := range ids {
+		p.thread.comments = append(p.thread.comments, commentInfo{id: b.rootID, threadID: id})
+	}
+	if len(ids) == 0 {
+		log.Printf("%s:%d – block has no thread ID, leaving it alone", path, b.start+1)
+		p.raw = blockLines(b)
+		return p
+	}
+
+	var stillOpen []string
+	excluded := false // written by an earlier run, before a stricter --trust
+	for _, id := range ids {
+		switch {
+		case ix.untrusted[id]:
+			excluded = true
+		case ix.open[id]:
+			stillOpen = append(stillOpen, id)
+		}
+	}
+	if blockEdited(b, ix.byID) {
+		switch {
+		case len(stillOpen) == 0 && excluded:
+			log.Printf("%s:%d – thread excluded by the trust policy but block was edited, leaving it alone", path, b.start+1)
+		case len(stillOpen) == 0:
+			log.Printf("%s:%d – thread resolved upstream but block was edited, leaving it alone", path, b.start+1)
+		}
+		p.raw = blockLines(b)
+		return p
+	}
+	if len(stillOpen) == 0 {
+		if excluded {
+			log.Printf("%s:%d – thread excluded by the trust policy, removing its block", path, b.start+1)
+		}
+		return nil
+	}
+
+	var cs []commentInfo
+	for _, id := range stillOpen {
+		if len(ix.byThread[id]) == 0 {
+			// Open, but not placeable from this run's data; keep as is.
+			p.raw = blockLines(b)
+			return p
+		}
+		cs = append(cs, ix.byThread[id]...)
+		if p.thread.note == "" {
+			p.thread.note = ix.notes[id]
+		}
+		if p.thread.original == nil && !p.plain {
+			p.thread.original = ix.originals[id]
+		}
+	}
+	sortComments(cs)
+	p.thread.comments = cs
+	return p
+}
+
+// blockEdited reports whether the user changed b since prconflict wrote it:
+// added REPLY: or RESOLVED lines, or changed a line prconflict generated, as
+// told by the checksum in the header. The source lines the block wraps are the
+// user's to edit. Blocks from before checksums are judged by re-rendering the
+// comments listed in their header; those that cannot be verified count as edited.
+func blockEdited(b reviewBlock, byID map[int64]commentInfo) bool {
+	if len(b.replies) > 0 || b.resolved {
+		return true
+	}
+	if b.sum != "" {
+		return blockSum(generatedLines(b)) != b.sum
+	}
+	if len(b.commentIDs) != b.count {
+		return true
+	}
+	cs := make([]commentInfo, 0, len(b.commentIDs))
+	for _, id := range b.commentIDs {
+		c, ok := byID[id]
+		if !ok {
+			return true
+		}
+		cs = append(cs, c)
+	}
+	// The header is skipped: resolved threads no longer report their thread ID.
+	th := lineThread{comments: cs, removed: b.removed, file: b.fileLevel}
+	if b.baseRev != "" {
+		th.original = &originalCode{rev: b.baseRev, lines: b.original}
+	}
+	if b.style.open != "" {
+		rendered := buildCommentBlock(th, b.style, b.indent, b.tag, b.suggestion)
+		return !equalLines(rendered[1:], b.lines[1:])
+	}
+	rendered := buildBlock(th, b.anchor, b.suggestion)
+	return !equalLines(rendered[1:], b.lines[1:])
+}
+
+// blockSum is the checksum of the lines prconflict generated for a block,
+// recorded in its header as sum=.
+func blockSum(lines []string) string {
+	h := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(h[:4])
+}
+
+// generatedLines returns the lines of b that blockSum covers: all but the
+// header and the anchored source.
+func generatedLines(b reviewBlock) []string {
+	lines := b.lines[1:]
+	switch {
+	case b.style.open != "":
+		return lines
+	case b.suggestion:
+		return lines[len(b.anchor):]
+	}
+	out := append([]string(nil), lines[:len(lines)-1-len(b.anchor)]...)
+	return append(out, lines[len(lines)-1])
+}
+
+// sumMatches reports whether b still carries the checksum it was written with,
+// leaving aside the REPLY: and RESOLVED lines the user is meant to add.
+func sumMatches(b reviewBlock) bool {
+	if b.sum == "" {
+		return false
+	}
+	extra, sep := b.body+len(b.comments)-b.start, b.sep-b.start
+	lines := append([]string(nil), b.lines[:extra]...)
+	i := extra
+	for ; i < sep && i < len(b.lines); i++ {
+		l := b.lines[i]
+		if strings.TrimSuffix(l, "\r") == markers.base {
+			break
+		}
+		if t := b.text(l); !strings.HasPrefix(t, replyPrefix) && strings.TrimSpace(t) != resolvedMark {
+			lines = append(lines, l)
+		}
+	}
+	b.lines = append(lines, b.lines[i:]...)
+	return blockSum(generatedLines(b)) == b.sum
+}
+
+func blockLines(b reviewBlock) []string {
+	return append([]string(nil), b.lines...)
+}
+
+func sortComments(cs []commentInfo) {
+	sort.SliceStable(cs, func(i, j int) bool { return cs[i].created.Before(cs[j].created) })
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// buildBlock renders the review block for th around the anchored source lines.
+// Unless suggest is false, threads carrying a ```suggestion become a two-sided
+// conflict: current code against the suggested replacement, with the
+// discussion in the base section. Threads on deleted lines wrap no source and
+// show the removed lines in the base section instead, with marker-like lines
+// escaped (see escapeCode); file-level threads wrap
+// no source either. Other threads that carry their original lines show them in
+// the base section, diff3 style, escaped the same way.
+func buildBlock(th lineThread, anchor []string, suggest bool) []string {
+	cs := th.comments
+	deleted := len(th.removed) > 0
+	suggested, isSuggestion := threadSuggestion(cs)
+	isSuggestion = isSuggestion && suggest && !deleted && !th.file
+	diff3 := th.original != nil && !isSuggestion && !deleted && !th.file
+	attrs := headerAttrs(cs)
+	switch {
+	case isSuggestion:
+		attrs = " suggestion" + attrs
+	case deleted:
+		attrs = " deleted" + attrs
+	case th.file:
+		attrs = " file" + attrs
+	}
+	if diff3 {
+		attrs += fmt.Sprintf(" base=%.7s", th.original.rev)
+	}
+	// Everything but the header and the anchored source, which the checksum covers.
+	var body []string
+	if isSuggestion {
+		body = append(body, markers.base)
+	}
+	for _, c := range cs {
+		body = append(body, markers.comment(c)...)
+	}
+	switch {
+	case deleted:
+		body = append(body, markers.base)
+		body = append(body, escapeCode(th.removed)...)
+	case diff3:
+		body = append(body, markers.base)
+		body = append(body, escapeCode(th.original.lines)...)
+	}
+	body = append(body, markers.separator)
+	if isSuggestion {
+		body = append(body, neutralizeCode(suggested)...)
+	}
+	body = append(body, markers.trailer)
+	attrs += " sum=" + blockSum(body)
+	if th.note != "" {
+		attrs += " (" + th.note + ")"
+	}
+	lines := []string{markers.header(cs, attrs, isSuggestion)}
+	if isSuggestion {
+		lines = append(lines, anchor...)
+		return append(lines, body...)
+	}
+	lines = append(lines, body[:len(body)-1]...)
+	lines = append(lines, anchor...)
+	return append(lines, markers.trailer)
+}
+
+// headerAttrs renders the thread and comment IDs that identify a block on rerun.
+func headerAttrs(cs []commentInfo) string {
+	var threads, ids []string
+	seen := map[string]bool{}
+	for _, c := range cs {
+		if c.threadID != "" && !seen[c.threadID] {
+			seen[c.threadID] = true
+			threads = append(threads, c.threadID)
+		}
+		if c.id != 0 {
+			ids = append(ids, strconv.FormatInt(c.id, 10))
+		}
+	}
+	var attrs string
+	if len(threads) > 0 {
+		attrs += " thread=" + strings.Join(threads, ",")
+	}
+	if len(ids) > 0 {
+		attrs += " comments=" + strings.Join(ids, ",")
+	}
+	return attrs
+}
+
+// preambleEnd returns where a file-level block goes: after a shebang and any
+// comment blocks set off by a blank line, such as a license header or build
+// constraints. A comment running straight into code documents it and stays
+// below the block.
+func preambleEnd(src []string) int {
+	i := 0
+	if len(src) > 0 && strings.HasPrefix(src[0], "#!") {
+		i = 1
+	}
+	for {
+		j := skipComments(src, i)
+		if j == i || j >= len(src) || strings.TrimSpace(src[j]) != "" {
+			return i
+		}
+		for j < len(src) && strings.TrimSpace(src[j]) == "" {
+			j++
+		}
+		i = j
+	}
+}
+
+// skipComments returns the index of the first line at or after i that is not
+// part of a comment.
+func skipComments(src []string, i int) int {
+	for i < len(src) {
+		l := strings.TrimSpace(src[i])
+		switch {
+		case strings.HasPrefix(l, "/*"):
+			for i < len(src) && !strings.Contains(src[i], "*/") {
+				i++
+			}
+		case strings.HasPrefix(l, "//"), strings.HasPrefix(l, "--"),
+			l == "#", strings.HasPrefix(l, "# "):
+		default:
+			return i
+		}
+		i++
+	}
+	return len(src)
+}
+
+// findInjectedFiles lists tracked files that contain review blocks, via git
+// grep. A committed REVIEW.md is left out.
+func findInjectedFiles() []string {
+	out, err := exec.Command("git", "grep", "-l", "-F", "-e", markers.headerPrefix, "-e", commentTagPrefix).Output()
+	if err != nil {
+		return nil // no matches, or not a git checkout
+	}
+	var paths []string
+	for _, p := range strings.Split(string(out), "\n") {
+		if p == "" {
+			continue
+		}
+		if data, err := os.ReadFile(p); err == nil && isReviewMarkdown(data) {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTemp(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file.go")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestInjectThreads_Idempotent(t *testing.T) {
+	path := writeTemp(t, "a\nb\nc\n")
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{
+		{line: 2, comments: []commentInfo{{id: 11, threadID: "T1", user: "alice", body: "why b?", created: ts}}},
+	}
+	known := &threadSet{open: map[string]bool{"T1": true}}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	first := readFile(t, path)
+	want := "a\n<<<<<<< REVIEW THREAD (1) thread=T1 comments=11 sum=cfb6f321\n2024-01-02 03:04 alice: why b?\n=======\nb\n>>>>>>> END REVIEW\nc\n"
+	if first != want {
+		t.Fatalf("first run:\n%s\nwant:\n%s", first, want)
+	}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if second := readFile(t, path); second != first {
+		t.Errorf("second run changed the file:\n%s", second)
+	}
+}
+
+func TestInjectThreads_UpdatesInPlace(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	c1 := commentInfo{id: 11, threadID: "T1", user: "alice", body: "why b?", created: ts}
+	c2 := commentInfo{id: 12, threadID: "T1", user: "bob", body: "agreed", created: ts.Add(time.Hour)}
+	c3 := commentInfo{id: 21, threadID: "T2", user: "carol", body: "typo", created: ts}
+
+	path := writeTemp(t, "a\nb\nc\n")
+	initial := []lineThread{
+		{line: 1, comments: []commentInfo{c3}},
+		{line: 2, comments: []commentInfo{c1}},
+	}
+	if _, err := injectThreads(path, initial, &threadSet{open: map[string]bool{"T1": true, "T2": true}}, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// T1 gets a reply, T2 is resolved upstream, and a new thread lands on line 3.
+	c4 := commentInfo{id: 31, threadID: "T3", user: "dave", body: "nit", created: ts}
+	next := []lineThread{
+		{line: 2, comments: []commentInfo{c1, c2}},
+		{line: 3, comments: []commentInfo{c4}},
+	}
+	known := &threadSet{
+		open:     map[string]bool{"T1": true, "T3": true},
+		comments: map[int64]commentInfo{c3.id: c3},
+	}
+	placed, err := injectThreads(path, next, known, "conflict", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	want := "a\n" +
+		"<<<<<<< REVIEW THREAD (2) thread=T1 comments=11,12 sum=c8aa913e\n2024-01-02 03:04 alice: why b?\n2024-01-02 04:04 bob: agreed\n=======\nb\n>>>>>>> END REVIEW\n" +
+		"<<<<<<< REVIEW THREAD (1) thread=T3 comments=31 sum=fcaa857c\n2024-01-02 03:04 dave: nit\n=======\nc\n>>>>>>> END REVIEW\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(placed) != 2 {
+		t.Errorf("placed %d threads, want 2", len(placed))
+	}
+}
+
+func TestInjectThreads_LeavesEditedBlocksAlone(t *testing.T) {
+	edited := "a\n<<<<<<< REVIEW THREAD (1) thread=T1 comments=11\n2024-01-02 03:04 alice: why b? (my note)\n=======\nb\n>>>>>>> END REVIEW\n"
+	path := writeTemp(t, edited)
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{
+		{line: 2, comments: []commentInfo{
+			{id: 11, threadID: "T1", user: "alice", body: "why b?", created: ts},
+			{id: 12, threadID: "T1", user: "bob", body: "new reply", created: ts.Add(time.Hour)},
+		}},
+	}
+
+	if _, err := injectThreads(path, threads, &threadSet{open: map[string]bool{"T1": true}}, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != edited {
+		t.Errorf("edited block was rewritten:\n%s", got)
+	}
+
+	// Even once resolved upstream, the user's edits survive.
+	if _, err := injectThreads(path, nil, &threadSet{}, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); !strings.Contains(got, "(my note)") {
+		t.Errorf("edited block was removed:\n%s", got)
+	}
+}
+
+func TestInjectThreads_UpstreamEdits(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	c1 := commentInfo{id: 11, threadID: "T1", user: "alice", body: "why b?", created: ts}
+	path := writeTemp(t, "a\nb\nc\n")
+	known := &threadSet{open: map[string]bool{"T1": true}}
+	if _, err := injectThreads(path, []lineThread{{line: 2, comments: []commentInfo{c1}}}, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	// The user fixes the code the block wraps, which is not an edit of the block.
+	if err := os.WriteFile(path, []byte(strings.Replace(readFile(t, path), "\nb\n", "\nB\n", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice rewords her comment on GitHub and bob replies; a wider --wrap changes nothing.
+	c1.body = "why b? It is unused."
+	c2 := commentInfo{id: 12, threadID: "T1", user: "bob", body: "agreed", created: ts.Add(time.Hour)}
+	saved := wrapWidth
+	wrapWidth = 120
+	t.Cleanup(func() { wrapWidth = saved })
+	known.comments = map[int64]commentInfo{}
+	if _, err := injectThreads(path, []lineThread{{line: 2, comments: []commentInfo{c1, c2}}}, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	if !strings.Contains(got, "alice: why b? It is unused.\n2024-01-02 04:04 bob: agreed\n=======\nB\n") {
+		t.Fatalf("block was not updated:\n%s", got)
+	}
+
+	// Resolved upstream, the block goes away and keeps the user's fix.
+	if _, err := injectThreads(path, nil, &threadSet{}, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "a\nB\nc\n" {
+		t.Errorf("resolved block was not removed:\n%s", got)
+	}
+}
+
+func TestBlockEdited_Checksum(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	th := lineThread{comments: []commentInfo{{id: 11, threadID: "T1", user: "alice", body: "why b?", created: ts}}}
+	block := buildBlock(th, []string{"b"}, true)
+	for _, tt := range []struct {
+		name   string
+		lines  []string
+		edited bool
+	}{
+		{"as written", block, false},
+		{"anchor changed", []string{block[0], block[1], block[2], "B", block[4]}, false},
+		{"comment changed", []string{block[0], block[1] + " (my note)", block[2], block[3], block[4]}, true},
+		{"reply added", []string{block[0], block[1], "REPLY: done", block[2], block[3], block[4]}, true},
+	} {
+		blocks, err := parseReviewBlocks(tt.lines)
+		if err != nil || len(blocks) != 1 {
+			t.Fatalf("%s: %v %+v", tt.name, err, blocks)
+		}
+		// Nothing is known about the comments: the checksum alone decides.
+		if got := blockEdited(blocks[0], nil); got != tt.edited {
+			t.Errorf("%s: blockEdited = %v, want %v", tt.name, got, tt.edited)
+		}
+	}
+}
+
+func TestInjectThreads_RangesMerge(t *testing.T) {
+	orig := "a\nb\nc\nd\ne\n"
+	path := writeTemp(t, orig)
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{
+		{line: 5, comments: []commentInfo{{id: 31, threadID: "T3", user: "carol", body: "last", created: ts}}},
+		{line: 4, start: 3, comments: []commentInfo{{id: 21, threadID: "T2", user: "bob", body: "c and d", created: ts.Add(time.Minute)}}},
+		{line: 3, start: 2, comments: []commentInfo{{id: 11, threadID: "T1", user: "alice", body: "b and c", created: ts}}},
+	}
+	known := &threadSet{open: map[string]bool{"T1": true, "T2": true, "T3": true}}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	want := "a\n" +
+		"<<<<<<< REVIEW THREAD (2) thread=T1,T2 comments=11,21 sum=fdfd3ac4\n" +
+		"2024-01-02 03:04 alice: b and c\n2024-01-02 03:05 bob: c and d\n" +
+		"=======\nb\nc\nd\n>>>>>>> END REVIEW\n" +
+		"<<<<<<< REVIEW THREAD (1) thread=T3 comments=31 sum=d5913114\n2024-01-02 03:04 carol: last\n=======\ne\n>>>>>>> END REVIEW\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if second := readFile(t, path); second != got {
+		t.Errorf("second run changed the file:\n%s", second)
+	}
+	if removed, _, err := cleanFile(path, strings.Split(got, "\n"), nil, false); err != nil || len(removed) != 2 {
+		t.Fatalf("cleanFile removed %d blocks, %v", len(removed), err)
+	}
+	if after := readFile(t, path); after != orig {
+		t.Errorf("clean did not restore the file:\n%s", after)
+	}
+}
+
+func TestPreambleEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want int
+	}{
+		{"plain", "package main\n", 0},
+		{"shebang", "#!/bin/sh\necho hi\n", 1},
+		{"license and build tags", "// Copyright 2024\n// MIT\n\n//go:build linux\n\npackage main\n", 5},
+		{"block license", "/*\n * Licensed under MIT\n */\n\n// Package x does y.\npackage x\n", 4},
+		{"doc comment", "// Package x does y.\npackage x\n", 0},
+		{"python", "#!/usr/bin/env python3\n# Copyright 2024\n\nimport os\n", 3},
+		{"c include", "#include <stdio.h>\n\nint x;\n", 0},
+	}
+	for _, tt := range tests {
+		src := strings.Split(strings.TrimSuffix(tt.src, "\n"), "\n")
+		if got := preambleEnd(src); got != tt.want {
+			t.Errorf("%s: preambleEnd = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInjectThreads_FileLevel(t *testing.T) {
+	orig := "// Copyright 2024\n\npackage main\n"
+	path := writeTemp(t, orig)
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{{file: true, comments: []commentInfo{{id: 9, threadID: "T9", user: "alice", body: "split this file", created: ts}}}}
+	known := &threadSet{open: map[string]bool{"T9": true}}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	want := "// Copyright 2024\n\n" +
+		"<<<<<<< REVIEW THREAD (1) file thread=T9 comments=9 sum=52d41725\n2024-01-02 03:04 alice: split this file\n=======\n>>>>>>> END REVIEW\n" +
+		"package main\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if second := readFile(t, path); second != got {
+		t.Errorf("second run changed the file:\n%s", second)
+	}
+}
@@ -56,7 +56,10 @@ func setupClients(t *testing.T) (ctx context.Context, ghREST *github.Client, ghQ
 
 func TestIntegration_GetUnresolvedCommentIDs(t *testing.T) {
 	ctx, _, ghQL, owner, repo, prNumber := setupClients(t)
-	ids := getUnresolvedCommentIDs(ctx, ghQL, owner, repo, prNumber)
+	ids, err := getUnresolvedCommentIDs(ctx, ghQL, owner, repo, prNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
 	fmt.Printf("Fetched %d unresolved comment IDs\n", len(ids))
 	for id := range ids {
 		fmt.Printf("Unresolved ID: %d\n", id)
@@ -79,7 +82,10 @@ func TestIntegration_GetUnresolvedCommentIDs(t *testing.T) {
 
 func TestIntegration_FetchReviewComments(t *testing.T) {
 	ctx, ghREST, _, owner, repo, prNumber := setupClients(t)
-	comments := fetchReviewComments(ctx, ghREST, owner, repo, prNumber)
+	comments, err := fetchReviewComments(ctx, ghREST, owner, repo, prNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
 	fmt.Printf("Fetched %d total review comments\n", len(comments))
 	for _, c := range comments {
 		fmt.Printf("Comment ID: %d, Path: %s, Line: %d\n", c.GetID(), c.GetPath(), c.GetLine())
@@ -110,12 +116,18 @@ func TestIntegration_FetchReviewComments(t *testing.T) {
 
 func TestIntegration_ConsistencyBetweenGraphQLAndREST(t *testing.T) {
 	ctx, ghREST, ghQL, owner, repo, prNumber := setupClients(t)
-	ids := getUnresolvedCommentIDs(ctx, ghQL, owner, repo, prNumber)
+	ids, err := getUnresolvedCommentIDs(ctx, ghQL, owner, repo, prNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
 	fmt.Printf("Unresolved IDs count: %d\n", len(ids))
 	for id := range ids {
 		fmt.Printf("Unresolved ID: %d\n", id)
 	}
-	comments := fetchReviewComments(ctx, ghREST, owner, repo, prNumber)
+	comments, err := fetchReviewComments(ctx, ghREST, owner, repo, prNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
 	fmt.Printf("Total comments fetched: %d\n", len(comments))
 	for _, c := range comments {
 		fmt.Printf("Fetched comment ID: %d\n", c.GetID())
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// hunk is one `git diff -U0` hunk header: old lines [oldStart, oldStart+oldCount)
+// became new lines [newStart, newStart+newCount).
+type hunk struct {
+	oldStart, oldCount int
+	newStart, newCount int
+}
+
+// lineMap translates line numbers of a file at some commit into the working tree.
+type lineMap struct {
+	hunks []hunk
+}
+
+var hunkRE = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parseHunks reads the hunk headers of a unified diff.
+func parseHunks(diff string) []hunk {
+	var hs []hunk
+	for _, l := range strings.Split(diff, "\n") {
+		m := hunkRE.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		h := hunk{oldCount: 1, newCount: 1}
+		h.oldStart, _ = strconv.Atoi(m[1])
+		if m[2] != "" {
+			h.oldCount, _ = strconv.Atoi(m[2])
+		}
+		h.newStart, _ = strconv.Atoi(m[3])
+		if m[4] != "" {
+			h.newCount, _ = strconv.Atoi(m[4])
+		}
+		hs = append(hs, h)
+	}
+	return hs
+}
+
+// mapLine returns where old line n is now. changed is true when the line itself
+// was modified or deleted; the result is then the start of the rewritten region.
+func (m lineMap) mapLine(n int) (line int, changed bool) {
+	shift := 0
+	for _, h := range m.hunks {
+		if h.oldCount == 0 {
+			// Pure insertion after old line oldStart.
+			if n > h.oldStart {
+				shift += h.newCount
+				continue
+			}
+			break
+		}
+		if n < h.oldStart {
+			break
+		}
+		if n < h.oldStart+h.oldCount {
+			return max(h.newStart, 1), true
+		}
+		shift += h.newCount - h.oldCount
+	}
+	return n + shift, false
+}
+
+// region returns the new-side lines of the hunk that rewrote old line n.
+func (m lineMap) region(n int) (start, count int) {
+	for _, h := range m.hunks {
+		if h.oldCount > 0 && n >= h.oldStart && n < h.oldStart+h.oldCount {
+			return h.newStart, h.newCount
+		}
+	}
+	return 0, 0
+}
+
+var lineMaps = map[string]lineMap{}
+
+// forgetWorkingTree drops what was learned about the working tree, so that a
+// long-running command sees the files and HEAD as they are now.
+func forgetWorkingTree() {
+	lineMaps = map[string]lineMap{}
+	renamedFrom = map[string]string{}
+	treeChanges = map[string]map[string]string{}
+}
+
+// diffLineMap diffs path at rev against the working tree, caching the result.
+// Review blocks already in the file are left out, as GitHub knows nothing of them.
+func diffLineMap(rev, path string) (lineMap, error) {
+	key := rev + ":" + path
+	if m, ok := lineMaps[key]; ok {
+		return m, nil
+	}
+	then := path
+	if old, ok := renamedFrom[path]; ok {
+		then = old
+	}
+	old, err := exec.Command("git", "show", rev+":"+then).Output()
+	if err != nil {
+		return lineMap{}, fmt.Errorf("%s not found at %.7s", then, rev)
+	}
+	cur, err := baseContent(path)
+	if err != nil {
+		return lineMap{}, err
+	}
+	dir, err := os.MkdirTemp("", "prconflict")
+	if err != nil {
+		return lineMap{}, err
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(a, old, 0600); err != nil {
+		return lineMap{}, err
+	}
+	if err := os.WriteFile(b, cur, 0600); err != nil {
+		return lineMap{}, err
+	}
+	out, err := exec.Command("git", "diff", "--no-index", "-U0", "--no-color", "--no-ext-diff", a, b).Output()
+	var exit *exec.ExitError
+	if err != nil && !(errors.As(err, &exit) && exit.ExitCode() == 1) {
+		return lineMap{}, fmt.Errorf("git diff %.7s: %w", rev, err)
+	}
+	m := lineMap{hunks: parseHunks(string(out))}
+	lineMaps[key] = m
+	return m, nil
+}
+
+// errFileDeleted reports a commented file that no longer exists under any name.
+var errFileDeleted = errors.New("file was deleted")
+
+// renamedFrom maps the current path of a renamed file to the path its
+// comments refer to.
+var renamedFrom = map[string]string{}
+
+// treeChanges caches, per commit, the files renamed or deleted since: old path
+// to new path, or "" for a deletion.
+var treeChanges = map[string]map[string]string{}
+
+// followRename returns where path at rev is found now, using git's rename
+// detection between rev and HEAD when it no longer exists under its own name.
+func followRename(rev, path string) (string, error) {
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := ensureCommit(rev); err != nil {
+		return "", err
+	}
+	changes, ok := treeChanges[rev]
+	if !ok {
+		out, err := exec.Command("git", "diff", "--name-status", "-z", "-M", "--diff-filter=RD", rev, "HEAD").Output()
+		if err != nil {
+			return "", fmt.Errorf("git diff %.7s HEAD: %w", rev, err)
+		}
+		changes = parseTreeChanges(string(out))
+		treeChanges[rev] = changes
+	}
+	now, ok := changes[path]
+	if !ok || now == "" {
+		return "", errFileDeleted // deleted at HEAD or in the working tree
+	}
+	if _, err := os.Stat(now); err != nil {
+		return "", errFileDeleted
+	}
+	renamedFrom[now] = path
+	return now, nil
+}
+
+// parseTreeChanges reads `git diff --name-status -z` output limited to
+// renames (R<score> old new) and deletions (D path).
+func parseTreeChanges(out string) map[string]string {
+	changes := map[string]string{}
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	for i := 0; i < len(fields); i++ {
+		switch status := fields[i]; {
+		case strings.HasPrefix(status, "R") && i+2 < len(fields):
+			changes[fields[i+1]] = fields[i+2]
+			i += 2
+		case status == "D" && i+1 < len(fields):
+			changes[fields[i+1]] = ""
+			i++
+		}
+	}
+	return changes
+}
+
+var fetchedCommits = map[string]error{}
+
+// ensureCommit makes sure rev exists locally, fetching it from origin once if
+// needed (e.g. after a force-push dropped it from the PR branch).
+func ensureCommit(rev string) error {
+	if rev == "" {
+		return errors.New("no commit recorded")
+	}
+	if err, done := fetchedCommits[rev]; done {
+		return err
+	}
+	err := exec.Command("git", "cat-file", "-e", rev+"^{commit}").Run()
+	if err != nil {
+		if ferr := exec.Command("git", "fetch", "-q", "origin", rev).Run(); ferr != nil {
+			err = fmt.Errorf("commit %.7s not available locally and could not be fetched", rev)
+		} else {
+			err = nil
+		}
+	}
+	fetchedCommits[rev] = err
+	return err
+}
+
+// originalCode is what a reviewer commented on, shown in diff3 base sections.
+type originalCode struct {
+	rev   string // the comment's original commit
+	lines []string
+}
+
+// originalLines returns the lines c was made on as of its original commit,
+// where the file lived at path.
+func originalLines(c *github.PullRequestComment, path string) (*originalCode, error) {
+	rev := c.GetOriginalCommitID()
+	if err := ensureCommit(rev); err != nil {
+		return nil, err
+	}
+	out, err := exec.Command("git", "show", rev+":"+path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s not found at %.7s", path, rev)
+	}
+	src := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	end := c.GetOriginalLine()
+	start := c.GetOriginalStartLine()
+	if start < 1 || start > end {
+		start = end
+	}
+	if end < 1 || end > len(src) {
+		return nil, fmt.Errorf("line %d not in %s at %.7s", end, path, rev)
+	}
+	return &originalCode{rev: rev, lines: src[start-1 : end]}, nil
+}
+
+// hunkLine returns the text of the commented line: the last line of a diff hunk.
+func hunkLine(diffHunk string) (string, bool) {
+	lines := strings.Split(strings.TrimRight(diffHunk, "\n"), "\n")
+	last := lines[len(lines)-1]
+	if last == "" || strings.HasPrefix(last, "@@") {
+		return "", false
+	}
+	return last[1:], true
+}
+
+// relocateOutdated finds where an outdated comment's line lives in the working
+// tree, starting from its original line at its original commit.
+func relocateOutdated(c *github.PullRequestComment) (int, error) {
+	orig := c.GetOriginalLine()
+	if orig == 0 {
+		return 0, errors.New("no original line")
+	}
+	return followLine(c.GetOriginalCommitID(), c.GetPath(), orig, c.GetDiffHunk())
+}
+
+// remapCurrent follows a current comment's lines from the PR head into the
+// working tree, which may carry local edits and unpushed commits. The range
+// start is dropped if its own line was rewritten.
+func remapCurrent(head string, c *github.PullRequestComment) (start, line int, err error) {
+	line, err = followLine(head, c.GetPath(), c.GetLine(), c.GetDiffHunk())
+	if err != nil || c.GetStartLine() == 0 {
+		return 0, line, err
+	}
+	m, err := diffLineMap(head, c.GetPath())
+	if err != nil {
+		return 0, 0, err
+	}
+	if s, changed := m.mapLine(c.GetStartLine()); !changed && s < line {
+		start = s
+	}
+	return start, line, nil
+}
+
+// rewrittenError reports that a line was modified in the working tree. The
+// new-side lines [start, start+count) replaced it; ambiguous is set when its
+// old text appears there more than once.
+type rewrittenError struct {
+	line         int
+	start, count int
+	ambiguous    bool
+}
+
+func (e *rewrittenError) Error() string {
+	if e.ambiguous {
+		return fmt.Sprintf("line %d was rewritten (ambiguous match)", e.line)
+	}
+	return fmt.Sprintf("line %d was rewritten", e.line)
+}
+
+// followLine maps line n of path at rev into the working tree. If the line
+// itself was rewritten, its old text (the last line of diffHunk) is looked
+// for in the rewritten region.
+func followLine(rev, path string, n int, diffHunk string) (int, error) {
+	if err := ensureCommit(rev); err != nil {
+		return 0, err
+	}
+	m, err := diffLineMap(rev, path)
+	if err != nil {
+		return 0, err
+	}
+	line, changed := m.mapLine(n)
+	if !changed {
+		return line, nil
+	}
+
+	start, count := m.region(n)
+	rewritten := &rewrittenError{line: n, start: start, count: count}
+	text, ok := hunkLine(diffHunk)
+	if !ok || strings.TrimSpace(text) == "" {
+		return 0, rewritten
+	}
+	src, err := readBase(path)
+	if err != nil {
+		return 0, err
+	}
+	found := 0
+	for i := start; i < start+count && i <= len(src); i++ {
+		if strings.TrimSpace(src[i-1]) == strings.TrimSpace(text) {
+			if found != 0 {
+				rewritten.ambiguous = true
+				return 0, rewritten
+			}
+			found = i
+		}
+	}
+	if found == 0 {
+		return 0, rewritten
+	}
+	return found, nil
+}
+
+// deletedLines walks the diff hunk of a comment on deleted lines, which ends
+// at the commented line, and returns the new-side line the deletion now sits
+// before together with the last n deleted lines.
+func deletedLines(diffHunk string, n int) (before int, removed []string, err error) {
+	lines := strings.Split(strings.TrimRight(diffHunk, "\n"), "\n")
+	m := hunkRE.FindStringSubmatch(lines[0])
+	if m == nil {
+		return 0, nil, errors.New("no diff hunk")
+	}
+	before, _ = strconv.Atoi(m[3])
+	if m[4] == "0" {
+		before++ // an empty new side starts after line newStart
+	}
+	var run []string
+	for _, l := range lines[1:] {
+		switch {
+		case strings.HasPrefix(l, "-"):
+			run = append(run, l[1:])
+			continue
+		case strings.HasPrefix(l, `\`):
+			continue // "\ No newline at end of file"
+		}
+		before++
+		run = nil
+	}
+	if len(run) == 0 {
+		return 0, nil, errors.New("diff hunk does not end on a deleted line")
+	}
+	n = min(max(n, 1), len(run))
+	return before, run[len(run)-n:], nil
+}
+
+// placeDeleted anchors a comment made on the LEFT side of the diff before the
+// line that now follows the deleted lines, following it into the working tree
+// from the PR head, or from its original commit if the comment is outdated.
+func placeDeleted(head string, c *github.PullRequestComment) (int, []string, error) {
+	first, last := c.GetStartLine(), c.GetLine()
+	if c.Line == nil {
+		first, last = c.GetOriginalStartLine(), c.GetOriginalLine()
+	}
+	n := 1
+	if c.GetStartSide() == "LEFT" && first > 0 && first < last {
+		n = last - first + 1
+	}
+	line, removed, err := deletedLines(c.GetDiffHunk(), n)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rev := head
+	if c.Line == nil {
+		rev = c.GetOriginalCommitID()
+	} else if head == "" {
+		return line, removed, nil
+	}
+	if err := ensureCommit(rev); err != nil {
+		return 0, nil, err
+	}
+	m, err := diffLineMap(rev, c.GetPath())
+	if err != nil {
+		return 0, nil, err
+	}
+	// If the following line was rewritten too, the start of the rewrite is close enough.
+	line, _ = m.mapLine(line)
+	return line, removed, nil
+}
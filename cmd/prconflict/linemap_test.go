@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestLineMap_MapLine(t *testing.T) {
+	diff := "diff --git a/f b/f\n" +
+		"@@ -2,0 +3,2 @@\n" + // two lines inserted after old line 2
+		"@@ -5 +7 @@\n" + // old line 5 rewritten
+		"@@ -8,2 +9,0 @@\n" // old lines 8-9 deleted
+	m := lineMap{hunks: parseHunks(diff)}
+
+	tests := []struct {
+		old, want int
+		changed   bool
+	}{
+		{1, 1, false},
+		{2, 2, false},
+		{3, 5, false},
+		{5, 7, true},
+		{6, 8, false},
+		{8, 9, true},
+		{10, 10, false},
+	}
+	for _, tt := range tests {
+		got, changed := m.mapLine(tt.old)
+		if got != tt.want || changed != tt.changed {
+			t.Errorf("mapLine(%d) = %d, %v; want %d, %v", tt.old, got, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestRelocateOutdated(t *testing.T) {
+	sha := gitRepo(t, "f.go", "a\nb\ntarget()\nc\n")
+	if err := os.WriteFile("f.go", []byte("new\na\nb\n\ttarget()\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clear(lineMaps)
+
+	c := &github.PullRequestComment{
+		Path:             github.Ptr("f.go"),
+		OriginalLine:     github.Ptr(3),
+		OriginalCommitID: github.Ptr(sha),
+		DiffHunk:         github.Ptr("@@ -1,2 +1,3 @@\n a\n b\n+target()"),
+	}
+	got, err := relocateOutdated(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 4 {
+		t.Errorf("relocated to line %d, want 4", got)
+	}
+
+	c.OriginalLine = github.Ptr(4)
+	c.DiffHunk = github.Ptr("@@ -1,3 +1,4 @@\n+gone()")
+	if err := os.WriteFile("f.go", []byte("a\nb\ntarget()\nreplaced\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clear(lineMaps)
+	if _, err := relocateOutdated(c); err == nil {
+		t.Error("expected rewritten line to be unplaceable")
+	}
+}
+
+func TestDeletedLines(t *testing.T) {
+	hunk := "@@ -10,6 +10,4 @@ func f() {\n" +
+		" \tkeep()\n" +
+		"-\told1()\n" +
+		"+\tnew1()\n" +
+		" \tkeep2()\n" +
+		"-\told2()\n" +
+		"-\told3()"
+	before, removed, err := deletedLines(hunk, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 13 || len(removed) != 2 || removed[0] != "\told2()" || removed[1] != "\told3()" {
+		t.Errorf("deletedLines = %d, %q; want 13, [old2 old3]", before, removed)
+	}
+
+	if _, _, err := deletedLines("@@ -1 +1 @@\n-a\n+b", 1); err == nil {
+		t.Error("expected an error for a hunk ending on an added line")
+	}
+}
+
+func TestInjectThreads_DeletedLines(t *testing.T) {
+	orig := "a\nd\n"
+	path := writeTemp(t, orig)
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{
+		{line: 2, removed: []string{"b", "c"}, comments: []commentInfo{{id: 7, threadID: "T7", user: "alice", body: "why drop these?", created: ts}}},
+		{line: 2, comments: []commentInfo{{id: 8, threadID: "T8", user: "bob", body: "ok", created: ts}}},
+	}
+	known := &threadSet{open: map[string]bool{"T7": true, "T8": true}}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	want := "a\n" +
+		"<<<<<<< REVIEW THREAD (1) deleted thread=T7 comments=7 sum=e9431e82\n" +
+		"2024-01-02 03:04 alice: why drop these?\n" +
+		"||||||| REVIEW\nb\nc\n=======\n>>>>>>> END REVIEW\n" +
+		"<<<<<<< REVIEW THREAD (1) thread=T8 comments=8 sum=225f62c0\n2024-01-02 03:04 bob: ok\n=======\nd\n>>>>>>> END REVIEW\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if second := readFile(t, path); second != got {
+		t.Errorf("second run changed the file:\n%s", second)
+	}
+	if _, _, err := cleanFile(path, strings.Split(got, "\n"), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if after := readFile(t, path); after != orig {
+		t.Errorf("clean did not restore the file:\n%s", after)
+	}
+}
+
+func TestInjectThreads_DeletedMarkerLines(t *testing.T) {
+	orig := "Title\nintro\n"
+	path := writeTemp(t, orig)
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	removed := []string{"gone", "=======", `\=======`, ">>>>>>> END REVIEW"}
+	threads := []lineThread{
+		{line: 2, removed: removed, comments: []commentInfo{{id: 7, threadID: "T7", user: "alice", body: "keep the underline?", created: ts}}},
+	}
+	known := &threadSet{open: map[string]bool{"T7": true}}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	want := "Title\n" +
+		"<<<<<<< REVIEW THREAD (1) deleted thread=T7 comments=7 sum=21bfe215\n" +
+		"2024-01-02 03:04 alice: keep the underline?\n" +
+		"||||||| REVIEW\ngone\n\\=======\n\\\\=======\n\\>>>>>>> END REVIEW\n=======\n>>>>>>> END REVIEW\nintro\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	blocks, err := parseReviewBlocks(strings.Split(got, "\n"))
+	if err != nil || len(blocks) != 1 {
+		t.Fatalf("blocks: %+v, %v", blocks, err)
+	}
+	if !slices.Equal(blocks[0].removed, removed) {
+		t.Errorf("removed = %q, want %q", blocks[0].removed, removed)
+	}
+
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if second := readFile(t, path); second != got {
+		t.Errorf("second run changed the file:\n%s", second)
+	}
+	if _, _, err := cleanFile(path, strings.Split(got, "\n"), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if after := readFile(t, path); after != orig {
+		t.Errorf("clean did not restore the file:\n%s", after)
+	}
+}
+
+func TestRemapCurrent(t *testing.T) {
+	head := gitRepo(t, "f.go", "a\nb\nc\nd\n")
+	// An unpushed local edit: one line added at the top, "d" rewritten.
+	if err := os.WriteFile("f.go", []byte("new\na\nb\nc\nD\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clear(lineMaps)
+
+	c := &github.PullRequestComment{
+		Path:      github.Ptr("f.go"),
+		StartLine: github.Ptr(2),
+		Line:      github.Ptr(3),
+		DiffHunk:  github.Ptr("@@ -1,3 +1,3 @@\n a\n b\n c"),
+	}
+	start, line, err := remapCurrent(head, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 3 || line != 4 {
+		t.Errorf("remapped to %d-%d, want 3-4", start, line)
+	}
+
+	c.StartLine, c.Line = nil, github.Ptr(4)
+	c.DiffHunk = github.Ptr("@@ -1,4 +1,4 @@\n c\n d")
+	if _, _, err := remapCurrent(head, c); err == nil {
+		t.Error("expected a locally rewritten line to be reported")
+	}
+}
+
+func TestPlanThreads_RewrittenLineNotMoved(t *testing.T) {
+	head := gitRepo(t, "f.go", "package f\n\nfunc a() error {\n\terr := x()\n\treturn err\n}\n\nfunc b() error {\n\terr := y()\n\treturn err\n}\n")
+	// The commented `return err` in a() is rewritten locally; b() still has one.
+	if err := os.WriteFile("f.go", []byte("package f\n\nfunc a() error {\n\terr := x()\n\treturn fmt.Errorf(\"a: %w\", err)\n}\n\nfunc b() error {\n\terr := y()\n\treturn err\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clear(lineMaps)
+
+	c := &github.PullRequestComment{
+		ID:       github.Ptr(int64(1)),
+		Path:     github.Ptr("f.go"),
+		CommitID: github.Ptr(head),
+		Line:     github.Ptr(5),
+		DiffHunk: github.Ptr("@@ -3,2 +3,3 @@ package f\n func a() error {\n \terr := x()\n+\treturn err"),
+	}
+	known := &threadSet{comments: map[int64]commentInfo{}}
+	plan := planThreads([]*github.PullRequestComment{c}, map[int64]string{1: "T1"}, head, known, false)
+	if len(plan.files["f.go"]) != 0 {
+		for _, th := range plan.files["f.go"] {
+			t.Errorf("thread placed on line %d (%s), want it unplaced", th.line, th.note)
+		}
+	}
+	if plan.unplaced != 1 {
+		t.Errorf("unplaced = %d, want 1", plan.unplaced)
+	}
+}
+
+func TestFollowRename(t *testing.T) {
+	gitRepo(t, "old.go", "a\nb\nc\n")
+	if err := os.WriteFile("gone.go", []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	commit := []string{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-qm", "change"}
+	git(t, "add", "gone.go")
+	git(t, commit...)
+	sha := git(t, "rev-parse", "HEAD")
+	git(t, "mv", "old.go", "new.go")
+	git(t, "rm", "-q", "gone.go")
+	git(t, commit...)
+	if err := os.WriteFile("new.go", []byte("new\na\nb\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clear(lineMaps)
+
+	got, err := followRename(sha, "old.go")
+	if err != nil || got != "new.go" {
+		t.Fatalf("followRename(old.go) = %q, %v; want new.go", got, err)
+	}
+	m, err := diffLineMap(sha, "new.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := m.mapLine(2); line != 3 {
+		t.Errorf("line 2 of old.go is now line %d, want 3", line)
+	}
+	if _, err := followRename(sha, "gone.go"); !errors.Is(err, errFileDeleted) {
+		t.Errorf("followRename(gone.go) = %v, want errFileDeleted", err)
+	}
+}
+
+func git(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestInjectThreads_Diff3(t *testing.T) {
+	sha := gitRepo(t, "f.go", "a\nb\n=======\nd\n")
+	orig := "a\nB\nC\nd\n"
+	if err := os.WriteFile("f.go", []byte(orig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &github.PullRequestComment{OriginalCommitID: github.Ptr(sha), OriginalStartLine: github.Ptr(2), OriginalLine: github.Ptr(3)}
+	original, err := originalLines(c, "f.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{{line: 3, start: 2, original: original, comments: []commentInfo{
+		{id: 7, threadID: "T7", user: "alice", body: "uppercase?", created: ts},
+	}}}
+	known := &threadSet{open: map[string]bool{"T7": true}}
+
+	if _, err := injectThreads("f.go", threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, "f.go")
+	want := "a\n" +
+		"<<<<<<< REVIEW THREAD (1) thread=T7 comments=7 base=" + sha[:7] + " sum=2a95cc53\n" +
+		"2024-01-02 03:04 alice: uppercase?\n" +
+		"||||||| REVIEW\nb\n\\=======\n=======\nB\nC\n>>>>>>> END REVIEW\nd\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Reruns keep the base section, even without the original lines at hand.
+	threads[0].original = nil
+	if _, err := injectThreads("f.go", threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if second := readFile(t, "f.go"); second != got {
+		t.Errorf("second run changed the file:\n%s", second)
+	}
+	src := strings.Split(strings.Replace(got, "uppercase?\n", "uppercase?\nREPLY: yes\n", 1), "\n")
+	blocks, err := parseReviewBlocks(src)
+	if err != nil || len(blocks) != 1 || len(blocks[0].replies) != 1 {
+		t.Fatalf("blocks: %+v, %v", blocks, err)
+	}
+	if want := []string{"b", "======="}; !slices.Equal(blocks[0].original, want) {
+		t.Errorf("original = %q, want %q", blocks[0].original, want)
+	}
+	if s := strings.Join(stripReplies(src, blocks), "\n"); s != got {
+		t.Errorf("stripReplies:\n%s", s)
+	}
+	if _, _, err := cleanFile("f.go", strings.Split(got, "\n"), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if after := readFile(t, "f.go"); after != orig {
+		t.Errorf("clean did not restore the file:\n%s", after)
+	}
+}
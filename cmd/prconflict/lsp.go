@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lspSeverities maps --severity values to LSP diagnostic severities.
+var lspSeverities = map[string]int{"error": 1, "warning": 2, "information": 3, "hint": 4}
+
+// Commands the server executes for its code actions.
+const (
+	lspResolve = "prconflict.resolve" // arguments: thread ID
+	lspReply   = "prconflict.reply"   // arguments: thread ID, reply text, optionally the document's URI
+	lspRefresh = "prconflict.refresh" // fetch the threads again
+)
+
+// lspServer publishes review threads to an editor over the Language Server
+// Protocol. It reads files but never writes them.
+type lspServer struct {
+	out       io.Writer
+	severity  int
+	docs      map[string][]string // open documents by path, as the editor has them
+	files     map[string]map[threadKey]*lineThread
+	published map[string]bool   // paths whose last published diagnostics were not empty
+	inserted  map[string]string // header of the block offered by Reply… per thread, for threads without one
+	requests  int               // requests sent to the editor
+	every     time.Duration     // how often to fetch the threads again; 0 only on request
+
+	// Talking to GitHub, replaced in tests.
+	fetch   func() (map[string]map[threadKey]*lineThread, error)
+	resolve func(threadID string) error
+	reply   func(threadID, body string) error
+}
+
+// runLSP implements `prconflict lsp`: a language server on stdin and stdout
+// that shows unresolved review threads as diagnostics, placed like
+// injectThreads would place them, with code actions to resolve, reply and
+// apply suggestions.
+func runLSP(args []string) {
+	fset := flag.NewFlagSet("lsp", flag.ExitOnError)
+	tmpl := addTemplateFlag(fset)
+	target := addPRFlags(fset)
+	policy := addTrustFlags(fset)
+	severity := fset.String("severity", "information", "Diagnostic severity: error, warning, information or hint")
+	every := fset.Duration("refresh", 5*time.Minute, "How often to fetch the threads again; 0 only on prconflict.refresh")
+	fset.Parse(args)
+	useTemplate(*tmpl)
+	sev, ok := lspSeverities[*severity]
+	if !ok {
+		log.Fatalf("unknown --severity %q", *severity)
+	}
+
+	// The PR is looked up once the editor has said where the workspace is.
+	var (
+		ctx          = context.Background()
+		owner, repo  string
+		pr           int
+		resolver     *GraphQLResolver
+		fetchThreads func() (map[string]map[threadKey]*lineThread, error)
+	)
+	// connect is retried on every request until it succeeds, so a missing
+	// token or a failed lookup can be fixed without restarting the server.
+	connect := func() error {
+		if resolver != nil {
+			return nil
+		}
+		o, r, n, err := target.resolve()
+		if err != nil {
+			return err
+		}
+		p, err := policy.policy()
+		if err != nil {
+			return err
+		}
+		ghREST, ghQL, err := newClients(ctx)
+		if err != nil {
+			return err
+		}
+		owner, repo, pr, trust = o, r, n, p
+		resolver = NewGraphQLResolver(ghQL)
+		fetchThreads = func() (map[string]map[threadKey]*lineThread, error) {
+			unresolvedIDs, err := getUnresolvedCommentIDs(ctx, ghQL, owner, repo, pr)
+			if err != nil {
+				return nil, err
+			}
+			known := &threadSet{open: map[string]bool{}, comments: map[int64]commentInfo{}}
+			comments, err := fetchReviewComments(ctx, ghREST, owner, repo, pr)
+			if err != nil {
+				return nil, err
+			}
+			forgetWorkingTree()
+			plan := planThreads(comments, unresolvedIDs, prHead(ctx, ghREST, owner, repo, pr), known, false)
+			reportUntrusted(plan.untrusted)
+			return plan.files, nil
+		}
+		return nil
+	}
+	s := &lspServer{
+		out:      os.Stdout,
+		severity: sev,
+		every:    *every,
+		fetch: func() (map[string]map[threadKey]*lineThread, error) {
+			if err := connect(); err != nil {
+				return nil, err
+			}
+			return fetchThreads()
+		},
+		resolve: func(id string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			return resolver.ResolveThread(ctx, id)
+		},
+		reply: func(id, body string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			_, err := postReplies(ctx, resolver, owner, repo, pr, []pendingReply{{threadID: id, body: body}}, true)
+			return err
+		},
+	}
+	if err := s.serve(os.Stdin); err != nil {
+		log.Fatalf("lsp: %v", err)
+	}
+}
+
+// lspMessage is a JSON-RPC 2.0 request, response or notification.
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspCommand struct {
+	Title     string `json:"title"`
+	Command   string `json:"command"`
+	Arguments []any  `json:"arguments,omitempty"`
+}
+
+type lspWorkspaceEdit struct {
+	Changes map[string][]lspTextEdit `json:"changes"`
+}
+
+type lspCodeAction struct {
+	Title   string            `json:"title"`
+	Kind    string            `json:"kind"`
+	Command *lspCommand       `json:"command,omitempty"`
+	Edit    *lspWorkspaceEdit `json:"edit,omitempty"`
+}
+
+// readLSPMessage reads one message framed by a Content-Length header.
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if v, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("bad Content-Length %q", v)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without Content-Length")
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+func (s *lspServer) send(msg lspMessage) {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("lsp: %v", err)
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (s *lspServer) notify(method string, params any) {
+	data, _ := json.Marshal(params)
+	s.send(lspMessage{Method: method, Params: data})
+}
+
+// serve handles messages from r until the client sends exit or hangs up, and
+// fetches the threads again every s.every in between.
+func (s *lspServer) serve(r io.Reader) error {
+	if s.docs == nil {
+		s.docs = map[string][]string{}
+	}
+	s.published = map[string]bool{}
+	s.inserted = map[string]string{}
+
+	// Messages are read on their own so that the timer fires while the editor is quiet.
+	msgs, failed, done := make(chan []byte), make(chan error, 1), make(chan struct{})
+	defer close(done)
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			data, err := readLSPMessage(br)
+			if err != nil {
+				failed <- err
+				return
+			}
+			select {
+			case msgs <- data:
+			case <-done:
+				return
+			}
+		}
+	}()
+	var tick <-chan time.Time
+	if s.every > 0 {
+		t := time.NewTicker(s.every)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		var data []byte
+		select {
+		case <-tick:
+			s.refresh()
+			continue
+		case err := <-failed:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case data = <-msgs:
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return fmt.Errorf("bad message: %w", err)
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if msg.Method == "" {
+			continue // the editor's answer to one of our requests
+		}
+		result, err := s.handle(msg.Method, msg.Params)
+		if msg.ID == nil {
+			if err != nil {
+				log.Printf("lsp: %s: %v", msg.Method, err)
+			}
+			continue
+		}
+		resp := lspMessage{ID: msg.ID, Result: result}
+		if err != nil {
+			var le *lspError
+			if !errors.As(err, &le) {
+				le = &lspError{Code: -32603, Message: err.Error()}
+			}
+			resp.Error, resp.Result = le, nil
+		} else if result == nil {
+			resp.Result = json.RawMessage("null")
+		}
+		s.send(resp)
+	}
+}
+
+// handle answers one request or notification.
+func (s *lspServer) handle(method string, params json.RawMessage) (any, error) {
+	var p struct {
+		RootURI               string `json:"rootUri"`
+		InitializationOptions struct {
+			Severity string `json:"severity"`
+		} `json:"initializationOptions"`
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+		Range     lspRange          `json:"range"`
+		Command   string            `json:"command"`
+		Arguments []json.RawMessage `json:"arguments"`
+	}
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &lspError{Code: -32602, Message: err.Error()}
+		}
+	}
+	path := uriPath(p.TextDocument.URI)
+
+	switch method {
+	case "initialize":
+		if sev, ok := lspSeverities[p.InitializationOptions.Severity]; ok {
+			s.severity = sev
+		}
+		if root := uriPath(p.RootURI); root != "" {
+			// Review paths are relative to the repository root.
+			if top, err := exec.Command("git", "-C", root, "rev-parse", "--show-toplevel").Output(); err == nil {
+				root = strings.TrimSpace(string(top))
+			}
+			if err := os.Chdir(root); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       map[string]any{"openClose": true, "change": 1},
+				"codeActionProvider":     true,
+				"executeCommandProvider": map[string]any{"commands": []string{lspResolve, lspReply, lspRefresh}},
+			},
+			"serverInfo": map[string]string{"name": "prconflict"},
+		}, nil
+	case "initialized":
+		s.refresh()
+	case "shutdown", "textDocument/didSave":
+	case "textDocument/didOpen":
+		s.docs[path] = splitDoc(p.TextDocument.Text)
+		s.publish(path)
+	case "textDocument/didChange":
+		if n := len(p.ContentChanges); n > 0 {
+			s.docs[path] = splitDoc(p.ContentChanges[n-1].Text)
+			s.publish(path)
+		}
+	case "textDocument/didClose":
+		delete(s.docs, path)
+		s.publish(path)
+	case "textDocument/codeAction":
+		return s.codeActions(path, p.TextDocument.URI, p.Range), nil
+	case "workspace/executeCommand":
+		return nil, s.execute(p.Command, p.Arguments)
+	default:
+		if strings.HasPrefix(method, "$/") {
+			return nil, nil
+		}
+		return nil, &lspError{Code: -32601, Message: "method not found: " + method}
+	}
+	return nil, nil
+}
+
+// refresh fetches the threads again and republishes every affected file.
+func (s *lspServer) refresh() {
+	files, err := s.fetch()
+	if err != nil {
+		s.notify("window/showMessage", map[string]any{"type": 1, "message": "prconflict: " + err.Error()})
+		return
+	}
+	s.files = files
+	paths := map[string]bool{}
+	for path := range files {
+		paths[path] = true
+	}
+	for path := range s.published {
+		paths[path] = true
+	}
+	var sorted []string
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	for _, path := range sorted {
+		s.publish(path)
+	}
+}
+
+// lspThread is one review thread positioned in a document.
+type lspThread struct {
+	id         string
+	th         lineThread // with this thread's comments only
+	start, end int        // 0-based document lines the thread is on
+	contiguous bool       // no review block splits the range
+}
+
+// lines returns path as the editor has it, or as it is on disk.
+func (s *lspServer) lines(path string) []string {
+	if src, ok := s.docs[path]; ok {
+		return src
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return splitDoc(string(data))
+}
+
+// threadsIn positions the threads of path in its current text. Thread lines
+// refer to the file without review blocks, as they do for injectThreads.
+func (s *lspServer) threadsIn(path string) []lspThread {
+	if len(s.files[path]) == 0 {
+		return nil
+	}
+	src := s.lines(path)
+	index, err := lineIndex(src)
+	if err != nil {
+		log.Printf("%s: %v", path, err)
+		return nil
+	}
+	base := make([]string, len(index))
+	for i, k := range index {
+		base[i] = src[k]
+	}
+	docLine := func(n int) int {
+		if n < 1 || n > len(index) {
+			return max(len(src)-1, 0)
+		}
+		return index[n-1]
+	}
+
+	var out []lspThread
+	for _, th := range s.files[path] {
+		first, last := th.line, th.line
+		switch {
+		case th.file:
+			first = preambleEnd(base) + 1
+			last = first
+		case th.start > 0:
+			first = th.start
+		}
+		a, b := docLine(first), docLine(last)
+		byThread := map[string][]commentInfo{}
+		var ids []string
+		for _, c := range th.comments {
+			if byThread[c.threadID] == nil {
+				ids = append(ids, c.threadID)
+			}
+			byThread[c.threadID] = append(byThread[c.threadID], c)
+		}
+		for _, id := range ids {
+			one := *th
+			one.comments = byThread[id]
+			sortComments(one.comments)
+			out = append(out, lspThread{id: id, th: one, start: a, end: b, contiguous: b-a == last-first})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].start != out[j].start {
+			return out[i].start < out[j].start
+		}
+		return out[i].id < out[j].id
+	})
+	return out
+}
+
+// publish sends the diagnostics of path, or clears them.
+func (s *lspServer) publish(path string) {
+	src := s.lines(path)
+	diags := []lspDiagnostic{}
+	for _, t := range s.threadsIn(path) {
+		var msg strings.Builder
+		for i, c := range t.th.comments {
+			if i > 0 {
+				msg.WriteString("\n\n")
+			}
+			fmt.Fprintf(&msg, "%s (%s): %s", neutralize(c.user), c.created.Format("2006-01-02 15:04"), strings.TrimSpace(neutralize(c.body)))
+		}
+		if t.th.note != "" {
+			fmt.Fprintf(&msg, "\n\n(%s)", t.th.note)
+		}
+		r := lspRange{Start: lspPosition{Line: t.start}, End: lspPosition{Line: t.end}}
+		if t.start < len(src) {
+			r.Start.Character = utf16Len(leadingSpace(src[t.start]))
+		}
+		if t.end < len(src) {
+			r.End.Character = utf16Len(src[t.end])
+		}
+		diags = append(diags, lspDiagnostic{Range: r, Severity: s.severity, Code: t.id, Source: "prconflict", Message: msg.String()})
+	}
+	if len(diags) == 0 && !s.published[path] {
+		return
+	}
+	s.published[path] = len(diags) > 0
+	s.notify("textDocument/publishDiagnostics", map[string]any{"uri": pathURI(path), "diagnostics": diags})
+}
+
+// codeActions offers to resolve, reply to or apply the suggestion of every
+// thread on the lines of r.
+func (s *lspServer) codeActions(path, uri string, r lspRange) []lspCodeAction {
+	actions := []lspCodeAction{}
+	src := s.lines(path)
+	blocks, _ := parseReviewBlocks(src) // threadsIn reports files that do not parse
+	for _, t := range s.threadsIn(path) {
+		if t.end < r.Start.Line || t.start > r.End.Line {
+			continue
+		}
+		who := neutralize(t.th.comments[0].user)
+		lines, ok := threadSuggestion(t.th.comments)
+		if ok && t.contiguous && !t.th.file && len(t.th.removed) == 0 && len(hostileContent(strings.Join(lines, "\n"))) == 0 {
+			actions = append(actions, editAction("Apply suggestion from "+who, uri,
+				lspRange{Start: lspPosition{Line: t.start}, End: lspPosition{Line: t.end + 1}}, lines))
+		}
+		actions = append(actions,
+			lspCodeAction{Title: "Resolve thread by " + who, Kind: "quickfix", Command: &lspCommand{Title: "Resolve thread", Command: lspResolve, Arguments: []any{t.id}}})
+		if a, ok := s.replyAction(t, uri, src, blocks, who); ok {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// replyAction answers t without help from the editor, which LSP gives no way
+// to prompt for text: it adds a REPLY: line to the thread's block, injecting
+// the block if the document does not show it yet. Once the block holds a
+// reply, the action posts it instead.
+func (s *lspServer) replyAction(t lspThread, uri string, src []string, blocks []reviewBlock, who string) (lspCodeAction, bool) {
+	if b, ok := blockFor(blocks, t.id); ok {
+		if reply := strings.TrimSpace(strings.Join(b.replies, "\n")); reply != "" {
+			return lspCodeAction{Title: "Post reply to " + who, Kind: "quickfix",
+				Command: &lspCommand{Title: "Post reply", Command: lspReply, Arguments: []any{t.id, reply, uri}}}, true
+		}
+		line := replyPrefix + " "
+		if b.style.open != "" {
+			line = b.indent + b.style.open + " " + line + b.style.close
+		}
+		at := lspPosition{Line: b.body + len(b.comments)}
+		return editAction("Reply to "+who+"…", uri, lspRange{Start: at, End: at}, []string{line}), true
+	}
+	if !t.contiguous || t.end >= len(src) {
+		return lspCodeAction{}, false
+	}
+	r := lspRange{Start: lspPosition{Line: t.start}, End: lspPosition{Line: t.start}}
+	var anchor []string
+	if !t.th.file && len(t.th.removed) == 0 {
+		anchor = src[t.start : t.end+1]
+		r.End.Line = t.end + 1
+	}
+	lines := buildBlock(t.th, anchor, false)
+	at := 1
+	for _, c := range t.th.comments {
+		at += len(markers.comment(c))
+	}
+	lines = slices.Insert(lines, at, replyPrefix+" ")
+	s.inserted[t.id] = lines[0]
+	return editAction("Reply to "+who+"…", uri, r, lines), true
+}
+
+// blockFor returns the block showing thread id.
+func blockFor(blocks []reviewBlock, id string) (reviewBlock, bool) {
+	for _, b := range blocks {
+		if slices.Contains(b.threadIDs, id) {
+			return b, true
+		}
+	}
+	return reviewBlock{}, false
+}
+
+// editAction replaces r in the document at uri with lines.
+func editAction(title, uri string, r lspRange, lines []string) lspCodeAction {
+	return lspCodeAction{Title: title, Kind: "quickfix", Edit: &lspWorkspaceEdit{Changes: map[string][]lspTextEdit{
+		uri: {{Range: r, NewText: strings.Join(append(lines, ""), "\n")}},
+	}}}
+}
+
+// clearReplies asks the editor to remove the posted REPLY: lines of thread id
+// from the document at uri, as push-replies does on disk. A block that only
+// came in with the Reply… action is taken out again, anchor lines kept.
+func (s *lspServer) clearReplies(uri, id string) {
+	src := s.lines(uriPath(uri))
+	blocks, err := parseReviewBlocks(src)
+	if err != nil {
+		return
+	}
+	b, ok := blockFor(blocks, id)
+	if !ok || len(b.replies) == 0 {
+		return
+	}
+	out := stripReplies(src, []reviewBlock{b})
+	if header, ok := s.inserted[id]; ok && header == src[b.start] {
+		out = removeBlocks(src, []reviewBlock{b})
+		delete(s.inserted, id)
+	}
+	end := b.end + 1 - (len(src) - len(out))
+	s.requests++
+	reqID := json.RawMessage(strconv.Itoa(s.requests))
+	params, _ := json.Marshal(map[string]any{
+		"label": "Posted reply",
+		"edit": editAction("", uri, lspRange{Start: lspPosition{Line: b.start}, End: lspPosition{Line: b.end + 1}},
+			out[b.start:end]).Edit,
+	})
+	s.send(lspMessage{ID: &reqID, Method: "workspace/applyEdit", Params: params})
+}
+
+// execute runs one of the server's commands, then shows the threads as they are now.
+func (s *lspServer) execute(command string, args []json.RawMessage) error {
+	var strs []string
+	for _, a := range args {
+		var v string
+		if err := json.Unmarshal(a, &v); err != nil {
+			return &lspError{Code: -32602, Message: fmt.Sprintf("%s: arguments must be strings", command)}
+		}
+		strs = append(strs, v)
+	}
+	var err error
+	switch {
+	case command == lspRefresh:
+	case command == lspResolve && len(strs) == 1:
+		err = s.resolve(strs[0])
+	case command == lspReply && (len(strs) == 2 || len(strs) == 3) && strings.TrimSpace(strs[1]) != "":
+		if err = s.reply(strs[0], strs[1]); err == nil && len(strs) == 3 {
+			s.clearReplies(strs[2], strs[0])
+		}
+	case command == lspReply:
+		// The Reply… action adds a REPLY: line instead; scripts pass the text along.
+		return &lspError{Code: -32602, Message: lspReply + ": pass the reply text as the second argument"}
+	default:
+		return &lspError{Code: -32602, Message: fmt.Sprintf("unknown command %s with %d argument(s)", command, len(strs))}
+	}
+	if err != nil {
+		return err
+	}
+	s.refresh()
+	return nil
+}
+
+// splitDoc splits document text into lines, without the final newline.
+func splitDoc(text string) []string {
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+}
+
+// uriPath turns a file URI into a path relative to the working directory when
+// it lies below it, like the paths of review comments.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	path := filepath.FromSlash(u.Path)
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return path
+}
+
+func pathURI(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// utf16Len is the length of s in UTF-16 code units, LSP's default column unit.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	return n
+}
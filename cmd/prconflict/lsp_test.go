@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLSPServer(t *testing.T) {
+	// Line 2 already carries a block from an earlier run, which shifts the rest down.
+	gitRepo(t, "f.go", "package f\n<<<<<<< REVIEW THREAD (1) thread=T1 comments=11\n2024-01-02 03:04 alice: why?\n=======\nvar a = 1\n>>>>>>> END REVIEW\nvar b = 2\nvar c = 3\n")
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	files := map[string]map[threadKey]*lineThread{"f.go": {
+		{line: 4}: {line: 4, start: 3, comments: []commentInfo{
+			{id: 21, threadID: "T2", user: "bob", created: ts, body: "merge:\n```suggestion\nvar b, c = 2, 3\n```"},
+		}},
+	}}
+	var resolved, replied []string
+	var out bytes.Buffer
+	fetches := 0
+	s := &lspServer{
+		out:      &out,
+		severity: lspSeverities["warning"],
+		fetch:    func() (map[string]map[threadKey]*lineThread, error) { fetches++; return files, nil },
+		resolve:  func(id string) error { resolved = append(resolved, id); return nil },
+		reply:    func(id, body string) error { replied = append(replied, id+": "+body); return nil },
+	}
+
+	uri := pathURI("f.go")
+	replied2 := "package f\n<<<<<<< REVIEW THREAD (1) thread=T1 comments=11\n2024-01-02 03:04 alice: why?\n=======\nvar a = 1\n>>>>>>> END REVIEW\n" +
+		"<<<<<<< REVIEW THREAD (1) thread=T2 comments=21 sum=da2ce0d9\n2024-01-02 03:04 bob: merge:\n    [suggestion]\nREPLY: done too\n=======\nvar b = 2\nvar c = 3\n>>>>>>> END REVIEW\n"
+	var in bytes.Buffer
+	for _, m := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"` + uri + `","text":"package f\n<<<<<<< REVIEW THREAD (1) thread=T1 comments=11\n2024-01-02 03:04 alice: why?\n=======\nvar a = 1\n>>>>>>> END REVIEW\nvar b = 2\nvar c = 3\n"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/codeAction","params":{"textDocument":{"uri":"` + uri + `"},"range":{"start":{"line":7,"character":0},"end":{"line":7,"character":0}}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"workspace/executeCommand","params":{"command":"prconflict.reply","arguments":["T2"]}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"workspace/executeCommand","params":{"command":"prconflict.reply","arguments":["T2","done"]}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"workspace/executeCommand","params":{"command":"prconflict.resolve","arguments":["T2"]}}`,
+		// The editor applied the Reply… action and the user typed an answer.
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"` + uri + `"},"contentChanges":[{"text":` + strconv.Quote(replied2) + `}]}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"textDocument/codeAction","params":{"textDocument":{"uri":"` + uri + `"},"range":{"start":{"line":11,"character":0},"end":{"line":11,"character":0}}}}`,
+		`{"jsonrpc":"2.0","id":8,"method":"workspace/executeCommand","params":{"command":"prconflict.reply","arguments":["T2","done too","` + uri + `"]}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didSave","params":{"textDocument":{"uri":"` + uri + `"}}}`,
+		`{"jsonrpc":"2.0","id":1,"result":{"applied":true}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	if err := s.serve(&in); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []map[string]any
+	r := bufio.NewReader(&out)
+	for {
+		data, err := readLSPMessage(r)
+		if err != nil {
+			break
+		}
+		var m map[string]any
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, m)
+	}
+	byID := map[float64]map[string]any{}
+	var diags []any
+	var edits []any
+	for _, m := range msgs {
+		if m["method"] == "workspace/applyEdit" {
+			edits = append(edits, m["params"])
+		}
+		if id, ok := m["id"].(float64); ok {
+			byID[id] = m
+		}
+		if m["method"] == "textDocument/publishDiagnostics" {
+			diags = append(diags, m["params"].(map[string]any)["diagnostics"])
+		}
+	}
+
+	// Published once fetched, again when the editor opens or changes the file and after each command.
+	if len(diags) != 6 {
+		t.Fatalf("diagnostics: %v", msgs)
+	}
+	d, _ := json.Marshal(diags[1])
+	if want := `[{"code":"T2","message":"bob (2024-01-02 03:04): merge:\n` + "```suggestion\\nvar b, c = 2, 3\\n```" + `","range":{"end":{"character":9,"line":7},"start":{"character":0,"line":6}},"severity":2,"source":"prconflict"}]`; string(d) != want {
+		t.Errorf("diagnostics:\n%s\nwant:\n%s", d, want)
+	}
+
+	actions, _ := json.Marshal(byID[2]["result"])
+	for _, want := range []string{
+		`"title":"Apply suggestion from bob"`,
+		`"newText":"var b, c = 2, 3\n","range":{"end":{"character":0,"line":8},"start":{"character":0,"line":6}}`,
+		`"arguments":["T2"],"command":"prconflict.resolve"`,
+		`"title":"Reply to bob…"`,
+		`"newText":"\u003c\u003c\u003c\u003c\u003c\u003c\u003c REVIEW THREAD (1) thread=T2 comments=21 sum=da2ce0d9\n2024-01-02 03:04 bob: merge:\n    [suggestion]\nREPLY: \n=======\nvar b = 2\nvar c = 3\n\u003e\u003e\u003e\u003e\u003e\u003e\u003e END REVIEW\n"`,
+	} {
+		if !strings.Contains(string(actions), want) {
+			t.Errorf("code actions lack %s:\n%s", want, actions)
+		}
+	}
+	if byID[3]["error"] == nil || byID[4]["error"] != nil || byID[5]["error"] != nil || byID[8]["error"] != nil {
+		t.Errorf("executeCommand responses: %v %v %v %v", byID[3], byID[4], byID[5], byID[8])
+	}
+
+	// With a reply typed into the block, the action posts it; the block only
+	// came with the Reply… action, so the edit puts the anchored lines back.
+	actions, _ = json.Marshal(byID[7]["result"])
+	if want := `"arguments":["T2","done too","` + uri + `"],"command":"prconflict.reply","title":"Post reply"`; !strings.Contains(string(actions), want) {
+		t.Errorf("code actions lack %s:\n%s", want, actions)
+	}
+	e, _ := json.Marshal(edits)
+	if want := `"newText":"var b = 2\nvar c = 3\n","range":{"end":{"character":0,"line":14},"start":{"character":0,"line":6}}`; len(edits) != 1 || !strings.Contains(string(e), want) {
+		t.Errorf("applyEdit requests:\n%s\nwant %s", e, want)
+	}
+	if strings.Join(replied, ",") != "T2: done,T2: done too" || strings.Join(resolved, ",") != "T2" {
+		t.Errorf("replied %v, resolved %v", replied, resolved)
+	}
+	if _, ok := byID[6]["result"]; !ok {
+		t.Errorf("shutdown: %v", byID[6])
+	}
+	// Once when initialized and after each successful command, not on save.
+	if fetches != 4 {
+		t.Errorf("fetched %d times, want 4", fetches)
+	}
+}
+
+func TestLSPServer_Timer(t *testing.T) {
+	fetched := make(chan bool, 10)
+	s := &lspServer{
+		out:   io.Discard,
+		every: time.Millisecond,
+		fetch: func() (map[string]map[threadKey]*lineThread, error) {
+			select {
+			case fetched <- true:
+			default:
+			}
+			return nil, nil
+		},
+	}
+	r, w := io.Pipe()
+	errc := make(chan error, 1)
+	go func() { errc <- s.serve(r) }()
+	// No message arrives, yet the threads are fetched again.
+	for range 3 {
+		select {
+		case <-fetched:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no refresh while the editor is quiet")
+		}
+	}
+	w.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLSPServer_FetchError(t *testing.T) {
+	var out bytes.Buffer
+	s := &lspServer{
+		out: &out,
+		fetch: func() (map[string]map[threadKey]*lineThread, error) {
+			return nil, errors.New("GITHUB_TOKEN env var missing")
+		},
+	}
+	var in bytes.Buffer
+	for _, m := range []string{
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	if err := s.serve(&in); err != nil {
+		t.Fatal(err)
+	}
+	if want := `"method":"window/showMessage","params":{"message":"prconflict: GITHUB_TOKEN env var missing","type":1}`; !strings.Contains(out.String(), want) {
+		t.Errorf("output lacks %s:\n%s", want, out.String())
+	}
+}
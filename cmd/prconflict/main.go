@@ -9,7 +9,15 @@
 // Build & Run
 //
 //	GITHUB_TOKEN=<pat> gh pr checkout <PR#>
-//	go run ./prconflict --repo owner/repo --pr <PR#> [--dry-run]
+//	go run ./prconflict --repo owner/repo --pr <PR#> [--dry-run] [--diff3] [--review-md REVIEW.md [--review-md-since 14d]]
+//	go run ./prconflict --trust OWNER,MEMBER,COLLABORATOR [--untrusted quarantine|skip]
+//	go run ./prconflict --style comments [--wrap 80]
+//	go run ./prconflict --format json|sarif|quickfix|patch
+//	go run ./prconflict clean [--dry-run] [paths...]
+//	go run ./prconflict push-replies [--submit=false] [paths...]
+//	go run ./prconflict sync [--dry-run] [paths...]
+//	go run ./prconflict apply-suggestions [--reviewer login] [--path p] [--commit] [--resolve]
+//	go run ./prconflict lsp [--severity error|warning|information|hint] [--refresh 5m]
 //
 // Requirements
 //   - Go 1.21+
@@ -18,14 +26,15 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -38,138 +47,428 @@ import (
 
 // commentInfo holds minimal data for a review comment.
 type commentInfo struct {
-	id      int64
-	user    string
-	body    string
-	created time.Time
+	id       int64
+	threadID string // GraphQL node ID of the review thread
+	user     string
+	body     string
+	created  time.Time
+	updated  time.Time
+	url      string
+	outdated bool // no longer on a line of the PR head
 }
 
 type lineThread struct {
 	line     int
+	start    int           // first line of a multi-line comment, 0 if single-line
+	note     string        // shown in the header, e.g. how an outdated thread was placed
+	removed  []string      // LEFT-side thread: the deleted lines, which sat before line
+	file     bool          // thread on the whole file rather than on lines
+	original *originalCode // with --diff3: the lines as the reviewer saw them
 	comments []commentInfo
 }
 
+// threadKey groups comments into blocks. Comments on deleted lines get their
+// own block before the line that now follows them.
+type threadKey struct {
+	line    int
+	deleted bool
+}
+
 func main() {
-	repoFlag := flag.String("repo", "", "GitHub repo in owner/name format (optional, autodetected)")
-	prNum := flag.Int("pr", 0, "Pull request number (optional, autodetected)")
-	branchFlag := flag.String("branch", "", "Git branch name for PR detection (optional)")
-	dryRun := flag.Bool("dry-run", false, "Print changes instead of writing files")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "clean":
+			runClean(os.Args[2:])
+			return
+		case "push-replies":
+			runPushReplies(os.Args[2:])
+			return
+		case "sync":
+			runSync(os.Args[2:])
+			return
+		case "apply-suggestions":
+			runApplySuggestions(os.Args[2:])
+			return
+		case "lsp":
+			runLSP(os.Args[2:])
+			return
+		}
+	}
+
+	target := addPRFlags(flag.CommandLine)
+	policy := addTrustFlags(flag.CommandLine)
+	dryRun := flag.Bool("dry-run", false, "Print changes as a patch instead of writing files")
+	reviewMD := flag.String("review-md", "", "Also write review summaries and PR conversation to this file (e.g. REVIEW.md), relative to the repository root")
+	reviewSince := flag.String("review-md-since", defaultReviewSince, "Only write review summaries and comments since then to --review-md: days (14d), a duration (36h), a date (2024-05-01) or all; standing change requests are always written")
+	format := flag.String("format", "conflict", "Output: conflict (inject blocks into files), patch (print them as a diff), quickfix, or "+strings.Join(reportFormats, ", ")+" (print threads to stdout)")
+	tmpl := addTemplateFlag(flag.CommandLine)
+	diff3 := flag.Bool("diff3", false, "Also show the commented lines as the reviewer saw them, in a base section like git's diff3 conflict style")
+	style := flag.String("style", "conflict", "How threads are written into files: conflict (git conflict markers) or comments (the language's own comments)")
+	wrap := flag.Int("wrap", wrapWidth, "Wrap comment text at this column; 0 keeps the reviewer's lines")
 	flag.Parse()
+	useTemplate(*tmpl)
+	if *wrap < 0 {
+		log.Fatalf("--wrap must not be negative")
+	}
+	wrapWidth = *wrap
+	policy.use()
+	since, err := parseSince(*reviewSince, time.Now())
+	if err != nil {
+		log.Fatalf("--review-md-since: %v", err)
+	}
+	if *style != "conflict" && *style != "comments" {
+		log.Fatalf("unknown --style %q", *style)
+	}
+	switch {
+	case *format == "patch":
+		*dryRun = true // same as --dry-run: print the diff, change nothing
+		*format = "conflict"
+	case *format != "conflict" && *format != "quickfix" && !slices.Contains(reportFormats, *format):
+		log.Fatalf("unknown --format %q", *format)
+	}
+
+	owner, repo, prNumVal, err := target.resolve()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	ghREST, ghQL, err := newClients(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 1. Get IDs of comments in unresolved threads (and their thread IDs) via GraphQL
+	unresolvedIDs, err := getUnresolvedCommentIDs(ctx, ghQL, owner, repo, prNumVal)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if slices.Contains(reportFormats, *format) {
+		comments, err := fetchReviewComments(ctx, ghREST, owner, repo, prNumVal)
+		if err != nil {
+			log.Fatal(err)
+		}
+		threads, untrusted := collectThreads(comments, unresolvedIDs)
+		reportUntrusted(untrusted)
+		if err := writeReport(os.Stdout, *format, owner+"/"+repo, prNumVal, threads); err != nil {
+			log.Fatalf("%s: %v", *format, err)
+		}
+		return
+	}
+	if *reviewMD != "" && *format == "conflict" {
+		writeReviewMarkdown(ctx, ghREST, owner, repo, prNumVal, *reviewMD, since, *dryRun)
+	}
+	existing := findInjectedFiles()
+	if len(unresolvedIDs) == 0 && len(existing) == 0 {
+		log.Println("All review threads resolved – nothing to do.")
+		return
+	}
+	known := &threadSet{open: map[string]bool{}, comments: map[int64]commentInfo{}}
+	for _, id := range unresolvedIDs {
+		known.open[id] = true
+	}
+
+	// 2. Fetch *all* review comments via REST (cheap) and keep only unresolved ones
+	comments, err := fetchReviewComments(ctx, ghREST, owner, repo, prNumVal)
+	if err != nil {
+		log.Fatal(err)
+	}
+	head := prHead(ctx, ghREST, owner, repo, prNumVal)
+
+	plan := planThreads(comments, unresolvedIDs, head, known, *diff3)
+	fileThreads := plan.files
+	reportHostile(fileThreads)
+	reportUntrusted(plan.untrusted)
+
+	if *format == "quickfix" {
+		writeQuickfix(os.Stdout, fileThreads)
+		return
+	}
+	if len(fileThreads) == 0 && len(existing) == 0 {
+		log.Println("No unresolved comments align with current lines – finished.")
+		return
+	}
+	// Files holding blocks from an earlier run are revisited so stale blocks get updated.
+	for _, path := range existing {
+		if fileThreads[path] == nil {
+			fileThreads[path] = map[threadKey]*lineThread{}
+		}
+	}
 
+	// 3. Inject conflict blocks, remembering what was placed for `prconflict sync`
+	var injected []injectedThread
+	for path, lineMap := range fileThreads {
+		var threads []lineThread
+		for _, t := range lineMap {
+			sort.Slice(t.comments, func(i, j int) bool {
+				return t.comments[i].created.Before(t.comments[j].created)
+			})
+			threads = append(threads, *t)
+		}
+		sort.Slice(threads, func(i, j int) bool { return threads[i].line > threads[j].line })
+		placed, err := injectThreads(path, threads, known, *style, *dryRun)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+		}
+		injected = append(injected, injectedFromThreads(path, placed)...)
+	}
+
+	if plan.unplaced > 0 {
+		log.Printf("%d thread(s) could not be placed – see above.", plan.unplaced)
+	}
+	if len(plan.deleted) > 0 {
+		log.Printf("%d thread(s) are on files that were deleted:", len(plan.deleted))
+		for _, l := range plan.deleted {
+			log.Printf("  %s", l)
+		}
+	}
+
+	if !*dryRun && len(injected) > 0 {
+		if err := recordInjected(owner+"/"+repo, prNumVal, injected); err != nil {
+			log.Printf("could not save sync state: %v", err)
+		}
+	}
+}
+
+// threadPlan is where the unresolved threads of a PR go in the working tree.
+type threadPlan struct {
+	files     map[string]map[threadKey]*lineThread
+	unplaced  int      // threads that could not be placed
+	deleted   []string // threads on files that no longer exist, sorted
+	untrusted []string // comments left out by the trust policy, sorted
+}
+
+// planThreads places every unresolved review comment on the working tree: it
+// follows renames, relocates outdated comments and those on deleted lines,
+// maps lines across local changes and checks them against the commented code.
+// Every comment, resolved or not, is also recorded in known. Comments by
+// authors the trust policy does not allow are left out, and threads left with
+// none are recorded in known.untrusted. With diff3, each thread on lines also
+// carries those lines as of the commit it was made on.
+func planThreads(comments []*github.PullRequestComment, unresolvedIDs map[int64]string, head string, known *threadSet, diff3 bool) threadPlan {
+	fileThreads := map[string]map[threadKey]*lineThread{}
+	unplaced := map[string]bool{}       // threads that could not be placed
+	unverified := map[string]bool{}     // threads placed by line number alone
+	deletedFiles := map[string]string{} // threads whose file no longer exists
+	bases := map[string][]string{}      // files without review blocks, for content anchoring
+	trusted := map[string]bool{}        // threads with a comment the trust policy lets through
+	excluded := map[string]bool{}       // threads with a comment it keeps out
+	var untrusted []string
+	for _, c := range comments {
+		threadID, keep := unresolvedIDs[c.GetID()]
+		info := commentInfo{
+			id:       c.GetID(),
+			threadID: threadID,
+			user:     nonEmpty(c.GetUser().GetLogin()),
+			body:     nonEmpty(c.GetBody()),
+			created:  c.GetCreatedAt().Time,
+			updated:  c.GetUpdatedAt().Time,
+			url:      c.GetHTMLURL(),
+			outdated: c.Line == nil && c.GetSubjectType() != "file",
+		}
+		known.comments[info.id] = info
+		if !keep {
+			continue // resolved – skip
+		}
+		if !trust.allows(c.GetAuthorAssociation()) {
+			untrusted = append(untrusted, untrustedComment(c))
+			excluded[threadID] = true
+			continue
+		}
+		trusted[threadID] = true
+		reviewedPath := c.GetPath()
+		// The file may have been renamed since the review; helpers then see its current path.
+		rev := c.GetCommitID()
+		if rev == "" {
+			rev = c.GetOriginalCommitID()
+		}
+		path, err := followRename(rev, c.GetPath())
+		if errors.Is(err, errFileDeleted) {
+			if _, seen := deletedFiles[threadID]; !seen {
+				deletedFiles[threadID] = fmt.Sprintf("%s (thread by %s)", c.GetPath(), info.user)
+			}
+			continue
+		}
+		if path != c.GetPath() {
+			c.Path = github.Ptr(path)
+		}
+		ln, start, note := c.GetLine(), c.GetStartLine(), ""
+		var removed []string
+		fileLevel := c.GetSubjectType() == "file"
+		switch {
+		case err != nil:
+		case fileLevel:
+			ln, start = 0, 0 // placed at the top of the file
+		case c.GetSide() == "LEFT":
+			// Made on deleted lines: anchor where they used to be.
+			ln, removed, err = placeDeleted(head, c)
+			start = 0
+			if err == nil && c.Line == nil {
+				note = fmt.Sprintf("outdated, relocated from L%d", c.GetOriginalLine())
+			}
+		case c.Line == nil:
+			// Outdated: follow the original line through git history instead.
+			ln, err = relocateOutdated(c)
+			start, note = 0, fmt.Sprintf("outdated, relocated from L%d", c.GetOriginalLine())
+		case head != "":
+			// The working tree may have moved on from the PR head.
+			start, ln, err = remapCurrent(head, c)
+		}
+		if removed == nil && !fileLevel && c.GetDiffHunk() != "" && path != "" {
+			// Line numbers are fragile: confirm the line by its content, or look for it.
+			if bases[path] == nil {
+				bases[path], _ = readBase(path)
+			}
+			hint, from, to := ln, 1, len(bases[path])
+			var rewritten *rewrittenError
+			switch {
+			case errors.As(err, &rewritten) && rewritten.ambiguous:
+				from, to = 1, 0 // the line map already found no single match
+			case errors.As(err, &rewritten):
+				// The line map knows which lines replaced the commented one;
+				// a look-alike elsewhere in the file is not it.
+				hint, from, to = rewritten.start, rewritten.start, rewritten.start+rewritten.count-1
+			case err != nil:
+				hint = c.GetLine()
+				if hint == 0 {
+					hint = c.GetOriginalLine()
+				}
+			}
+			found, score := anchorWithin(bases[path], c.GetDiffHunk(), hint, from, to)
+			switch {
+			case score >= minConfidence && (err != nil || found != ln || score < 1):
+				if start > 0 {
+					start += found - hint
+				}
+				ln, err = found, nil
+				note = strings.TrimPrefix(note+"; "+confidenceNote(hint, found, score), "; ")
+			case err == nil && score < minConfidence && !unverified[threadID]:
+				unverified[threadID] = true
+				log.Printf("%s:%d – commented code not found (best match %d%%), placing by line number", path, ln, int(score*100))
+			}
+		}
+		if err != nil {
+			if !unplaced[threadID] {
+				unplaced[threadID] = true
+				log.Printf("%s:L%d – thread by %s could not be placed: %v", c.GetPath(), c.GetOriginalLine(), info.user, err)
+			}
+			continue
+		}
+		key := threadKey{line: ln, deleted: removed != nil}
+		if fileThreads[path] == nil {
+			fileThreads[path] = map[threadKey]*lineThread{}
+		}
+		th := fileThreads[path][key]
+		if th == nil {
+			th = &lineThread{line: ln, note: note, removed: removed, file: fileLevel}
+			fileThreads[path][key] = th
+			if diff3 && removed == nil && !fileLevel {
+				if th.original, err = originalLines(c, reviewedPath); err != nil {
+					log.Printf("%s:%d – no base section: %v", path, ln, err)
+				}
+			}
+		}
+		if start > 0 && start < ln && (th.start == 0 || start < th.start) {
+			th.start = start
+		}
+		th.comments = append(th.comments, info)
+	}
+
+	// Blocks of threads kept out entirely are removed by injectThreads.
+	known.untrusted = map[string]bool{}
+	for id := range excluded {
+		if !trusted[id] {
+			known.untrusted[id] = true
+		}
+	}
+
+	plan := threadPlan{files: fileThreads, unplaced: len(unplaced), untrusted: untrusted}
+	for _, l := range deletedFiles {
+		plan.deleted = append(plan.deleted, l)
+	}
+	sort.Strings(plan.deleted)
+	sort.Strings(plan.untrusted)
+	return plan
+}
+
+// prFlags holds the flags shared by every command that talks to a pull request.
+type prFlags struct {
+	repo   *string
+	pr     *int
+	branch *string
+}
+
+func addPRFlags(fs *flag.FlagSet) prFlags {
+	return prFlags{
+		repo:   fs.String("repo", "", "GitHub repo in owner/name format (optional, autodetected)"),
+		pr:     fs.Int("pr", 0, "Pull request number (optional, autodetected)"),
+		branch: fs.String("branch", "", "Git branch name for PR detection (optional)"),
+	}
+}
+
+// resolve fills in missing flags via the gh CLI and returns owner, repo and PR number.
+func (f prFlags) resolve() (string, string, int, error) {
 	// Determine repository (owner/repo)
-	repoVal := *repoFlag
+	repoVal := *f.repo
 	if repoVal == "" {
 		out, err := exec.Command("gh", "repo", "view", "--json", "nameWithOwner", "--jq", ".nameWithOwner").Output()
 		if err != nil {
-			log.Fatalf("could not detect repository: %v", err)
+			return "", "", 0, fmt.Errorf("could not detect repository: %w", err)
 		}
 		repoVal = strings.TrimSpace(string(out))
 	}
 
 	// Determine PR number
-	prNumVal := *prNum
+	prNumVal := *f.pr
 	if prNumVal == 0 {
-		if *branchFlag != "" {
-			out, err := exec.Command("gh", "pr", "list", "--json", "number", "--head", *branchFlag).Output()
+		if *f.branch != "" {
+			out, err := exec.Command("gh", "pr", "list", "--json", "number", "--head", *f.branch).Output()
 			if err != nil {
-				log.Fatalf("could not detect PR number from branch %s: %v", *branchFlag, err)
+				return "", "", 0, fmt.Errorf("could not detect PR number from branch %s: %w", *f.branch, err)
 			}
 			var prs []struct{ Number int }
 			if err := json.Unmarshal(out, &prs); err != nil {
-				log.Fatalf("invalid JSON from gh pr list: %v", err)
+				return "", "", 0, fmt.Errorf("invalid JSON from gh pr list: %w", err)
 			}
 			if len(prs) == 0 {
-				log.Fatalf("no PR found for branch %s", *branchFlag)
+				return "", "", 0, fmt.Errorf("no PR found for branch %s", *f.branch)
 			}
 			prNumVal = prs[0].Number
 		} else {
 			out, err := exec.Command("gh", "pr", "view", "--json", "number", "--jq", ".number").Output()
 			if err != nil {
-				log.Fatalf("could not detect PR number: %v", err)
+				return "", "", 0, fmt.Errorf("could not detect PR number: %w", err)
 			}
 			num, err := strconv.Atoi(strings.TrimSpace(string(out)))
 			if err != nil {
-				log.Fatalf("invalid PR number from gh CLI: %v", err)
+				return "", "", 0, fmt.Errorf("invalid PR number from gh CLI: %w", err)
 			}
 			prNumVal = num
 		}
 	}
 
-	token := os.Getenv("GITHUB_TOKEN")
-	if token == "" {
-		log.Fatal("GITHUB_TOKEN env var missing – provide a PAT with repo scope")
-	}
-
 	owner, repo, ok := splitRepo(repoVal)
 	if !ok {
-		log.Fatalf("invalid repository format: %s", repoVal)
+		return "", "", 0, fmt.Errorf("invalid repository format: %s", repoVal)
+	}
+	return owner, repo, prNumVal, nil
+}
+
+// newClients builds REST and GraphQL clients authenticated with GITHUB_TOKEN.
+func newClients(ctx context.Context) (*github.Client, *githubv4.Client, error) {
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return nil, nil, errors.New("GITHUB_TOKEN env var missing – provide a PAT with repo scope")
 	}
 
 	// OAuth‑backed HTTP client for both REST and GraphQL
-	ctx := context.Background()
 	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 	httpClient := oauth2.NewClient(ctx, ts)
-
-	ghREST := github.NewClient(httpClient)
-	ghQL := githubv4.NewClient(httpClient)
-
-	// 1. Get IDs of comments in unresolved threads via GraphQL
-	unresolvedIDs := getUnresolvedCommentIDs(ctx, ghQL, owner, repo, prNumVal)
-	if len(unresolvedIDs) == 0 {
-		log.Println("All review threads resolved – nothing to do.")
-		return
-	}
-
-	// 2. Fetch *all* review comments via REST (cheap) and keep only unresolved ones
-	comments := fetchReviewComments(ctx, ghREST, owner, repo, prNumVal)
-
-	fileThreads := map[string]map[int]*lineThread{}
-	for _, c := range comments {
-		if c.Path == nil || c.Line == nil {
-			continue // outdated
-		}
-		if _, keep := unresolvedIDs[c.GetID()]; !keep {
-			continue // resolved – skip
-		}
-		path := c.GetPath()
-		ln := c.GetLine()
-		if fileThreads[path] == nil {
-			fileThreads[path] = map[int]*lineThread{}
-		}
-		if fileThreads[path][ln] == nil {
-			fileThreads[path][ln] = &lineThread{line: ln}
-		}
-		fileThreads[path][ln].comments = append(fileThreads[path][ln].comments, commentInfo{
-			id:      c.GetID(),
-			user:    nonEmpty(c.GetUser().GetLogin()),
-			body:    nonEmpty(c.GetBody()),
-			created: c.GetCreatedAt().Time,
-		})
-	}
-
-	if len(fileThreads) == 0 {
-		log.Println("No unresolved comments align with current lines – finished.")
-		return
-	}
-
-	// 3. Inject conflict blocks
-	for path, lineMap := range fileThreads {
-		var threads []lineThread
-		for _, t := range lineMap {
-			sort.Slice(t.comments, func(i, j int) bool {
-				return t.comments[i].created.Before(t.comments[j].created)
-			})
-			threads = append(threads, *t)
-		}
-		sort.Slice(threads, func(i, j int) bool { return threads[i].line > threads[j].line })
-		if err := injectThreads(path, threads, *dryRun); err != nil {
-			log.Printf("%s: %v", path, err)
-		}
-	}
+	return github.NewClient(httpClient), githubv4.NewClient(httpClient), nil
 }
 
-// getUnresolvedCommentIDs queries GraphQL v4 for unresolved threads and returns their comment DB IDs.
-func getUnresolvedCommentIDs(ctx context.Context, client *githubv4.Client, owner, repo string, prNumber int) map[int64]struct{} {
+// getUnresolvedCommentIDs queries GraphQL v4 for unresolved threads and maps their comment DB IDs
+// to the thread's node ID.
+func getUnresolvedCommentIDs(ctx context.Context, client *githubv4.Client, owner, repo string, prNumber int) (map[int64]string, error) {
 	type commentNode struct {
 		DatabaseID githubv4.Int `graphql:"databaseId"`
 	}
@@ -182,6 +481,7 @@ func getUnresolvedCommentIDs(ctx context.Context, client *githubv4.Client, owner
 						EndCursor   githubv4.String
 					}
 					Nodes []struct {
+						ID         githubv4.String
 						IsResolved githubv4.Boolean
 						Comments   struct {
 							Nodes []commentNode
@@ -198,18 +498,18 @@ func getUnresolvedCommentIDs(ctx context.Context, client *githubv4.Client, owner
 		"cursor": (*githubv4.String)(nil),
 	}
 
-	ids := make(map[int64]struct{})
+	ids := make(map[int64]string)
 
 	for {
 		if err := client.Query(ctx, &q, vars); err != nil {
-			log.Fatalf("GraphQL query: %v", err)
+			return nil, fmt.Errorf("GraphQL query: %w", err)
 		}
 		for _, th := range q.Repository.PullRequest.ReviewThreads.Nodes {
 			if bool(th.IsResolved) {
 				continue
 			}
 			for _, c := range th.Comments.Nodes {
-				ids[int64(c.DatabaseID)] = struct{}{}
+				ids[int64(c.DatabaseID)] = string(th.ID)
 			}
 		}
 		if !bool(q.Repository.PullRequest.ReviewThreads.PageInfo.HasNextPage) {
@@ -217,17 +517,17 @@ func getUnresolvedCommentIDs(ctx context.Context, client *githubv4.Client, owner
 		}
 		vars["cursor"] = githubv4.NewString(q.Repository.PullRequest.ReviewThreads.PageInfo.EndCursor)
 	}
-	return ids
+	return ids, nil
 }
 
 // fetchReviewComments uses REST to obtain path & line info for all comments.
-func fetchReviewComments(ctx context.Context, gh *github.Client, owner, repo string, pr int) []*github.PullRequestComment {
+func fetchReviewComments(ctx context.Context, gh *github.Client, owner, repo string, pr int) ([]*github.PullRequestComment, error) {
 	var all []*github.PullRequestComment
 	opts := &github.PullRequestListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
 	for {
 		cs, resp, err := gh.PullRequests.ListComments(ctx, owner, repo, pr, opts)
 		if err != nil {
-			log.Fatalf("ListComments: %v", err)
+			return nil, fmt.Errorf("ListComments: %w", err)
 		}
 		all = append(all, cs...)
 		if resp.NextPage == 0 {
@@ -235,56 +535,24 @@ func fetchReviewComments(ctx context.Context, gh *github.Client, owner, repo str
 		}
 		opts.Page = resp.NextPage
 	}
-	return all
+	return all, nil
 }
 
-// injectThreads writes review conflict blocks into a file.
-func injectThreads(path string, threads []lineThread, dry bool) error {
-	data, err := os.ReadFile(path)
+// prHead returns the PR's head commit if it is available locally, so comment
+// lines can be mapped onto a working tree that has moved on. It returns "" when
+// they have to be taken as they are.
+func prHead(ctx context.Context, gh *github.Client, owner, repo string, pr int) string {
+	p, _, err := gh.PullRequests.Get(ctx, owner, repo, pr)
 	if err != nil {
-		return err
-	}
-	var src []string
-	sc := bufio.NewScanner(strings.NewReader(string(data)))
-	for sc.Scan() {
-		src = append(src, sc.Text())
-	}
-	if err := sc.Err(); err != nil {
-		return err
-	}
-
-	for _, th := range threads {
-		idx := th.line - 1
-		if idx < 0 || idx >= len(src) {
-			log.Printf("%s:%d – line vanished, skipping", path, th.line)
-			continue
-		}
-		block := buildBlock(th.comments)
-		trailer := ">>>>>>> END REVIEW"
-		insertion := append(block, append([]string{src[idx]}, trailer)...)
-		src = append(src[:idx], append(insertion, src[idx+1:]...)...)
-	}
-
-	if dry {
-		fmt.Printf("--- %s (dry-run)\n", path)
-		for i, l := range src {
-			fmt.Printf("%6d %s\n", i+1, l)
-		}
-		return nil
+		log.Printf("could not get PR head, assuming the working tree matches it: %v", err)
+		return ""
 	}
-
-	return os.WriteFile(path, []byte(strings.Join(src, "\n")+"\n"), 0644)
-}
-
-func buildBlock(cs []commentInfo) []string {
-	header := fmt.Sprintf("<<<<<<< REVIEW THREAD (%d)", len(cs))
-	lines := []string{header}
-	for _, c := range cs {
-		ts := c.created.Format("2006-01-02 15:04")
-		lines = append(lines, fmt.Sprintf("%s %s: %s", ts, c.user, sanitize(c.body)))
+	sha := p.GetHead().GetSHA()
+	if err := ensureCommit(sha); err != nil {
+		log.Printf("%v – assuming the working tree matches the PR head", err)
+		return ""
 	}
-	lines = append(lines, "=======")
-	return lines
+	return sha
 }
 
 // helper utilities
@@ -296,11 +564,6 @@ func splitRepo(s string) (string, string, bool) {
 	return parts[0], parts[1], true
 }
 
-func sanitize(s string) string {
-	repl := strings.NewReplacer("\n", " ", "\r", " ", "*", "", "/", "")
-	return strings.TrimSpace(repl.Replace(s))
-}
-
 func nonEmpty(v string) string {
 	if v == "" {
 		return "unknown"
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultMarkers is the built-in block format. A --template file can redefine
+// any of these templates; the ones it leaves out keep their default.
+const defaultMarkers = `{{define "header"}}<<<<<<< REVIEW THREAD ({{.Count}}){{.Attrs}}{{end}}
+{{define "comment"}}{{.CreatedAt.Format "2006-01-02 15:04"}} {{.Author}}: {{.Body}}{{end}}
+{{define "base"}}||||||| REVIEW{{end}}
+{{define "separator"}}======={{end}}
+{{define "trailer"}}>>>>>>> END REVIEW{{end}}
+`
+
+// threadModel is what the "header" template renders.
+type threadModel struct {
+	Count      int    // comments in the block
+	Attrs      string // flags, IDs and checksum that identify the block on rerun; must be shown
+	ThreadID   string // GraphQL node ID of the first thread
+	Author     string // who started the thread
+	URL        string // the first comment on GitHub
+	IsOutdated bool
+	Suggestion bool // the block is a two-sided suggestion
+}
+
+// commentModel is what the "comment" template renders.
+type commentModel struct {
+	Author     string
+	Body       string // wrapped at --wrap, with suggestions shown as [suggestion]
+	RawBody    string // as written on GitHub
+	URL        string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ThreadID   string
+	IsOutdated bool
+	Suggestion bool // the comment carries a ```suggestion
+}
+
+// blockMarkers renders the lines of a review block from templates, and
+// recognizes them again for clean, sync and reruns.
+type blockMarkers struct {
+	tmpl                     *template.Template
+	headers                  []*regexp.Regexp // capture the count and the attributes
+	headerPrefix             string           // fixed text every header starts with
+	base, separator, trailer string
+}
+
+// markers is the block format in use.
+var markers = mustMarkers(newMarkers(defaultMarkers))
+
+// wrapWidth is the column comment text is wrapped at (--wrap); 0 keeps the
+// lines as the reviewer wrote them.
+var wrapWidth = 80
+
+// continuationIndent starts every line of a comment after the first.
+const continuationIndent = "    "
+
+// isContinuation reports whether line carries on the comment above it.
+func isContinuation(line string) bool {
+	line = strings.TrimSuffix(line, "\r")
+	return line == "" || line[0] == ' ' || line[0] == '\t'
+}
+
+func mustMarkers(m *blockMarkers, err error) *blockMarkers {
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// addTemplateFlag registers --template, which every command reading or writing
+// blocks needs to agree on. Pass its value to useTemplate after parsing.
+func addTemplateFlag(fs *flag.FlagSet) *string {
+	return fs.String("template", os.Getenv("PRCONFLICT_TEMPLATE"), "text/template file redefining the block markers (default $PRCONFLICT_TEMPLATE)")
+}
+
+// useTemplate switches to the block format defined in path, if any.
+func useTemplate(path string) {
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("--template: %v", err)
+	}
+	m, err := newMarkers(defaultMarkers, string(data))
+	if err != nil {
+		log.Fatalf("--template %s: %v", path, err)
+	}
+	markers = m
+}
+
+// Placeholders used to turn the header template into a pattern.
+const (
+	countMark = 987654321
+	attrsMark = "\x02"
+	textMark  = "\x01"
+)
+
+// newMarkers parses template definitions, later ones overriding earlier ones,
+// and checks that the result can be parsed back: the marker lines must be
+// fixed, and the header must be one line showing the count and attributes.
+func newMarkers(defs ...string) (*blockMarkers, error) {
+	t := template.New("markers")
+	for _, d := range defs {
+		if _, err := t.Parse(d); err != nil {
+			return nil, err
+		}
+	}
+	m := &blockMarkers{tmpl: t}
+
+	fixed := map[string]*string{"base": &m.base, "separator": &m.separator, "trailer": &m.trailer}
+	seen := map[string]string{}
+	for _, name := range []string{"base", "separator", "trailer"} {
+		line, err := m.exec(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		if line == "" || strings.ContainsAny(line, "\r\n") || isContinuation(line) {
+			return nil, fmt.Errorf("%q must render one non-empty, unindented line", name)
+		}
+		if other, dup := seen[line]; dup {
+			return nil, fmt.Errorf("%q and %q render the same line", other, name)
+		}
+		seen[line] = name
+		*fixed[name] = line
+	}
+
+	patterns := map[string]bool{}
+	var prefixes []string
+	for _, outdated := range []bool{false, true} {
+		for _, suggestion := range []bool{false, true} {
+			out, err := m.exec("header", threadModel{
+				Count: countMark, Attrs: attrsMark, ThreadID: textMark, Author: textMark, URL: textMark,
+				IsOutdated: outdated, Suggestion: suggestion,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if strings.ContainsAny(out, "\r\n") {
+				return nil, errors.New(`"header" must render one line`)
+			}
+			count := strconv.Itoa(countMark)
+			if !strings.Contains(out, count) || !strings.Contains(out, attrsMark) {
+				return nil, errors.New(`"header" must show {{.Count}} and {{.Attrs}}`)
+			}
+			fixedEnd := len(out)
+			for _, mark := range []string{count, attrsMark, textMark} {
+				if i := strings.Index(out, mark); i >= 0 {
+					fixedEnd = min(fixedEnd, i)
+				}
+			}
+			prefixes = append(prefixes, out[:fixedEnd])
+
+			pat := regexp.QuoteMeta(out)
+			pat = strings.Replace(pat, count, `(?P<count>\d+)`, 1)
+			pat = strings.ReplaceAll(pat, count, `\d+`)
+			pat = strings.Replace(pat, attrsMark, `(?P<attrs>(?: \S+)*)`, 1)
+			pat = strings.ReplaceAll(pat, attrsMark, `(?: \S+)*`)
+			pat = strings.ReplaceAll(pat, textMark, `.*?`)
+			if !patterns[pat] {
+				patterns[pat] = true
+				m.headers = append(m.headers, regexp.MustCompile("^"+pat+"$"))
+			}
+		}
+	}
+	// Try the patterns with the most fixed text first: a header rendered with
+	// .IsOutdated may otherwise pass for one without and its attributes.
+	sort.SliceStable(m.headers, func(i, j int) bool {
+		return len(m.headers[i].String()) > len(m.headers[j].String())
+	})
+	m.headerPrefix = prefixes[0]
+	for _, p := range prefixes[1:] {
+		m.headerPrefix = commonPrefix(m.headerPrefix, p)
+	}
+	if m.headerPrefix == "" {
+		// Files are scanned for this text before they are parsed.
+		return nil, errors.New(`"header" must start with fixed text`)
+	}
+	for line, name := range seen {
+		if m.parseable(line) {
+			return nil, fmt.Errorf("%q renders a line that reads as a header", name)
+		}
+	}
+
+	if _, err := m.exec("comment", commentModel{}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// commonPrefix returns the longest common prefix of a and b.
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i]
+}
+
+func (m *blockMarkers) exec(name string, data any) (string, error) {
+	var b strings.Builder
+	if err := m.tmpl.ExecuteTemplate(&b, name, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// render executes a template that passed the checks in newMarkers, as one line.
+func (m *blockMarkers) render(name string, data any) string {
+	out, err := m.exec(name, data)
+	if err != nil {
+		log.Fatalf("template %s: %v", name, err)
+	}
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(out)
+}
+
+// header renders the first line of a block holding cs.
+func (m *blockMarkers) header(cs []commentInfo, attrs string, suggestion bool) string {
+	th := threadModel{Count: len(cs), Attrs: attrs, Suggestion: suggestion}
+	if len(cs) > 0 {
+		th.ThreadID, th.Author, th.URL, th.IsOutdated = cs[0].threadID, neutralize(cs[0].user), neutralize(cs[0].url), cs[0].outdated
+	}
+	return m.render("header", th)
+}
+
+// comment renders the lines showing c: the template's output, with every line
+// after the first indented under it. Text from GitHub is neutralized, and a
+// first line that would read as block syntax is escaped with a backslash.
+func (m *blockMarkers) comment(c commentInfo) []string {
+	_, suggestion := parseSuggestion(c.body)
+	model := commentModel{
+		Author:     neutralize(c.user),
+		Body:       textMark,
+		RawBody:    neutralize(c.body),
+		URL:        neutralize(c.url),
+		CreatedAt:  c.created,
+		UpdatedAt:  c.updated,
+		ThreadID:   c.threadID,
+		IsOutdated: c.outdated,
+		Suggestion: suggestion,
+	}
+	// The body's first line shares its line with whatever the template puts
+	// before it.
+	first, rest := wrapWidth, wrapWidth-len(continuationIndent)
+	if out, err := m.exec("comment", model); err == nil {
+		if i := strings.Index(out, textMark); i >= 0 {
+			first -= utf8.RuneCountInString(out[strings.LastIndex(out[:i], "\n")+1 : i])
+		}
+	}
+	body := neutralize(withoutSuggestion(c.body))
+	model.Body = strings.Join(wrapBody(strings.Trim(body, "\n"), first, rest), "\n")
+	out, err := m.exec("comment", model)
+	if err != nil {
+		log.Fatalf("template comment: %v", err)
+	}
+
+	var lines []string
+	for i, l := range strings.Split(strings.ReplaceAll(out, "\r\n", "\n"), "\n") {
+		l = strings.TrimRight(l, " \t\r")
+		switch {
+		case i == 0 && m.collides(l):
+			l = `\` + l
+		case i > 0 && l != "":
+			l = continuationIndent + l
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// collides reports whether line, as the first line of a comment, would be
+// taken for block syntax: a marker, or the continuation of the comment before it.
+func (m *blockMarkers) collides(line string) bool {
+	return isContinuation(line) || m.forged(line)
+}
+
+// forged reports whether line reads as a block or git conflict marker.
+func (m *blockMarkers) forged(line string) bool {
+	line = strings.TrimSuffix(line, "\r")
+	return isConflictMarker(line) || strings.HasPrefix(line, m.headerPrefix) ||
+		line == m.base || line == m.separator || line == m.trailer || line == "======="
+}
+
+// wrapBody breaks the lines of a Markdown body at spaces so that the first
+// fits in first columns and the others in rest. Lines that already fit, fenced
+// code and words longer than the width, such as URLs, are kept as written.
+// Wrapping is off when wrapWidth is 0.
+func wrapBody(body string, first, rest int) []string {
+	var out []string
+	fenced := false
+	for _, l := range strings.Split(body, "\n") {
+		width := rest
+		if len(out) == 0 {
+			width = first
+		}
+		trimmed := strings.TrimSpace(l)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+		}
+		if fenced || wrapWidth <= 0 || utf8.RuneCountInString(l) <= width {
+			out = append(out, l)
+			continue
+		}
+		lead := leadingSpace(l)
+		cur := ""
+		for _, w := range strings.Fields(l) {
+			switch {
+			case cur == "":
+				cur = lead + w
+			case utf8.RuneCountInString(cur)+1+utf8.RuneCountInString(w) > width:
+				out = append(out, cur)
+				cur, width = lead+w, rest
+			default:
+				cur += " " + w
+			}
+		}
+		out = append(out, cur)
+	}
+	return out
+}
+
+// parseHeader reports whether line is a block header, with its comment count
+// and attributes.
+func (m *blockMarkers) parseHeader(line string) (int, string, bool) {
+	if !strings.HasPrefix(line, m.headerPrefix) {
+		return 0, "", false
+	}
+	for _, re := range m.headers {
+		if sm := re.FindStringSubmatch(line); sm != nil {
+			n, err := strconv.Atoi(sm[re.SubexpIndex("count")])
+			if err != nil {
+				return 0, "", false
+			}
+			return n, sm[re.SubexpIndex("attrs")], true
+		}
+	}
+	return 0, "", false
+}
+
+func (m *blockMarkers) parseable(line string) bool {
+	_, _, ok := m.parseHeader(line)
+	return ok
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkers_Template(t *testing.T) {
+	m, err := newMarkers(defaultMarkers, `
+{{define "header"}}<<<<<<< {{.Author}} asks ({{.Count}}){{.Attrs}}{{if .IsOutdated}} [outdated]{{end}}{{end}}
+{{define "comment"}}@{{.Author}} {{.CreatedAt.Format "Jan 2"}}: {{.Body}}{{if .URL}} <{{.URL}}>{{end}}{{end}}
+{{define "trailer"}}>>>>>>> DONE{{end}}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := markers
+	markers = m
+	t.Cleanup(func() { markers = saved })
+
+	orig := "a\nb\nc\n"
+	path := writeTemp(t, orig)
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{{line: 2, note: "outdated, relocated from L9", comments: []commentInfo{
+		{id: 11, threadID: "T1", user: "alice", body: "why\nb?", created: ts, url: "https://x/1", outdated: true},
+		{id: 12, threadID: "T1", user: "bob", body: "agreed", created: ts.Add(time.Hour)},
+	}}}
+	known := &threadSet{open: map[string]bool{"T1": true}}
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	want := "a\n" +
+		"<<<<<<< alice asks (2) thread=T1 comments=11,12 sum=3c57e671 (outdated, relocated from L9) [outdated]\n" +
+		"@alice Jan 2: why\n" +
+		"    b? <https://x/1>\n" +
+		"@bob Jan 2: agreed\n" +
+		"=======\nb\n>>>>>>> DONE\nc\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	blocks, err := parseReviewBlocks(strings.Split(got, "\n"))
+	if err != nil || len(blocks) != 1 {
+		t.Fatalf("blocks: %+v, %v", blocks, err)
+	}
+	if b := blocks[0]; b.note != "outdated, relocated from L9" || len(b.commentIDs) != 2 || b.threadIDs[0] != "T1" {
+		t.Errorf("parsed %+v", b)
+	}
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if second := readFile(t, path); second != got {
+		t.Errorf("second run changed the file:\n%s", second)
+	}
+	if _, _, err := cleanFile(path, strings.Split(got, "\n"), nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if after := readFile(t, path); after != orig {
+		t.Errorf("clean did not restore the file:\n%s", after)
+	}
+}
+
+func TestMarkers_CommentBody(t *testing.T) {
+	saved := wrapWidth
+	wrapWidth = 40
+	t.Cleanup(func() { wrapWidth = saved })
+
+	orig := "a\nb\n"
+	path := writeTemp(t, orig)
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{{line: 2, comments: []commentInfo{
+		{id: 11, threadID: "T1", user: "alice", created: ts, body: "See https://example.com/a/b and **bold** for why a/b is off by one here.\r\n\r\n```go\n=======\nx := a*b // a very long line of code that is not wrapped\n```"},
+		{id: 12, threadID: "T1", user: "bob", created: ts.Add(time.Hour), body: "\nRESOLVED\nREPLY: quoted"},
+	}}}
+	known := &threadSet{open: map[string]bool{"T1": true}}
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	want := "a\n" +
+		"<<<<<<< REVIEW THREAD (2) thread=T1 comments=11,12 sum=9809ed38\n" +
+		"2024-01-02 03:04 alice: See\n" +
+		"    https://example.com/a/b and **bold**\n" +
+		"    for why a/b is off by one here.\n" +
+		"\n" +
+		"    ```go\n" +
+		"    =======\n" +
+		"    x := a*b // a very long line of code that is not wrapped\n" +
+		"    ```\n" +
+		"2024-01-02 04:04 bob: RESOLVED\n" +
+		"    REPLY: quoted\n" +
+		"=======\nb\n>>>>>>> END REVIEW\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	blocks, err := parseReviewBlocks(strings.Split(got, "\n"))
+	if err != nil || len(blocks) != 1 {
+		t.Fatalf("blocks: %+v, %v", blocks, err)
+	}
+	if b := blocks[0]; b.count != 2 || len(b.comments) != 10 || len(b.replies) != 0 || b.resolved {
+		t.Errorf("parsed %+v", b)
+	}
+	if _, err := injectThreads(path, threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if second := readFile(t, path); second != got {
+		t.Errorf("second run changed the file:\n%s", second)
+	}
+
+	m, err := newMarkers(defaultMarkers, `{{define "comment"}}{{.Body}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := m.comment(commentInfo{body: "=======\nok"}); strings.Join(lines, "|") != `\=======|    ok` {
+		t.Errorf("escaped comment: %q", lines)
+	}
+}
+
+func TestMarkers_Invalid(t *testing.T) {
+	for _, def := range []string{
+		`{{define "header"}}<<<<<<< REVIEW{{.Attrs}}{{end}}`,
+		`{{define "header"}}{{.Author}} ({{.Count}}){{.Attrs}}{{end}}`,
+		`{{define "header"}}<<<<<<< ({{.Count}})` + "\n" + `{{.Attrs}}{{end}}`,
+		`{{define "separator"}}>>>>>>> END REVIEW{{end}}`,
+		`{{define "base"}}======={{end}}`,
+		`{{define "separator"}}    ======={{end}}`,
+		`{{define "trailer"}}<<<<<<< REVIEW THREAD (1){{end}}`,
+		`{{define "comment"}}{{.Nope}}{{end}}`,
+	} {
+		if _, err := newMarkers(defaultMarkers, def); err == nil {
+			t.Errorf("accepted %s", def)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct{ a, b string }{
+		{"", ""},
+		{"", "a\nb\n"},
+		{"a\nb\n", ""},
+		{"a\nb\nc\n", "a\nx\nb\nc\ny\n"},
+		{"a\nb\nc\nd\n", "b\nc\nX\n"},
+		{"a\nb", "a\nb\n"},
+	}
+	for _, tt := range tests {
+		var old, new strings.Builder
+		for _, op := range diffLines(strings.SplitAfter(tt.a, "\n"), strings.SplitAfter(tt.b, "\n")) {
+			if op.kind != '+' {
+				old.WriteString(op.line)
+			}
+			if op.kind != '-' {
+				new.WriteString(op.line)
+			}
+		}
+		if old.String() != tt.a || new.String() != tt.b {
+			t.Errorf("diffLines(%q, %q) rebuilds %q, %q", tt.a, tt.b, old.String(), new.String())
+		}
+	}
+}
+
+func TestUnifiedDiff_GitApply(t *testing.T) {
+	var lines []string
+	for i := 0; i < 30; i++ {
+		lines = append(lines, strings.Repeat("x", i))
+	}
+	orig := strings.Join(lines, "\n") // no final newline
+	gitRepo(t, "f.go", orig)
+
+	changed := append([]string(nil), lines...)
+	changed[2] = "changed"
+	changed = append(changed[:20], append([]string{"<<<<<<< REVIEW THREAD (1)", "c", "======="}, changed[20:]...)...)
+	next := strings.Join(changed, "\n") + "\n"
+
+	patch := unifiedDiff("f.go", orig, next, false)
+	// Two edits, plus the final newline added at the end.
+	if strings.Count(patch, "@@ -") != 3 || !strings.Contains(patch, "\\ No newline at end of file\n") {
+		t.Errorf("unexpected hunks:\n%s", patch)
+	}
+	cmd := exec.Command("git", "apply", "-")
+	cmd.Stdin = strings.NewReader(patch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply: %v: %s\n%s", err, out, patch)
+	}
+	if got := readFile(t, "f.go"); got != next {
+		t.Errorf("applied patch gives:\n%s", got)
+	}
+
+	// The reverse patch restores the original.
+	cmd = exec.Command("git", "apply", "-R", "-")
+	cmd.Stdin = strings.NewReader(patch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply -R: %v: %s", err, out)
+	}
+	if got, _ := os.ReadFile("f.go"); string(got) != orig {
+		t.Errorf("reverse patch gives:\n%s", got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// writeQuickfix prints one `path:line:col: [reviewer] text` entry per thread,
+// as Vim's :cexpr and Emacs' compile-mode parse them. The rest of the
+// discussion follows on indented continuation lines. Line numbers point into
+// the file as it is, review blocks from an earlier run included.
+func writeQuickfix(w io.Writer, fileThreads map[string]map[threadKey]*lineThread) {
+	var paths []string
+	for path := range fileThreads {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		src, index, err := fileIndex(path)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			continue
+		}
+		base := make([]string, len(index))
+		for i, k := range index {
+			base[i] = src[k]
+		}
+		// lineOf returns the line of the file a thread points at: the first
+		// line of its range, or for blocks wrapping no source, the line they precede.
+		lineOf := func(th *lineThread) int {
+			n := th.line
+			switch {
+			case th.file:
+				n = preambleEnd(base) + 1
+			case th.start > 0:
+				n = th.start
+			}
+			if n < 1 || n > len(index) {
+				return max(len(src), 1)
+			}
+			return index[n-1] + 1
+		}
+		var threads []*lineThread
+		for _, th := range fileThreads[path] {
+			threads = append(threads, th)
+		}
+		sort.Slice(threads, func(i, j int) bool { return lineOf(threads[i]) < lineOf(threads[j]) })
+
+		for _, th := range threads {
+			if len(th.comments) == 0 {
+				continue
+			}
+			sortComments(th.comments)
+			line, col := lineOf(th), 1
+			if line <= len(src) {
+				col += len(src[line-1]) - len(strings.TrimLeft(src[line-1], " \t"))
+			}
+			first := th.comments[0]
+			body := strings.Split(strings.TrimSpace(neutralize(first.body)), "\n")
+			msg := body[0]
+			if th.note != "" {
+				msg += " (" + th.note + ")"
+			}
+			fmt.Fprintf(w, "%s:%d:%d: [%s] %s\n", path, line, col, neutralize(first.user), msg)
+			for _, l := range body[1:] {
+				fmt.Fprintf(w, "    %s\n", l)
+			}
+			for _, c := range th.comments[1:] {
+				for i, l := range strings.Split(strings.TrimSpace(neutralize(c.body)), "\n") {
+					if i == 0 {
+						l = "[" + neutralize(c.user) + "] " + l
+					}
+					fmt.Fprintf(w, "    %s\n", l)
+				}
+			}
+		}
+	}
+}
+
+// fileIndex reads path and maps each line of its block-free view (the one
+// thread lines refer to) to the index of that line in the file.
+func fileIndex(path string) ([]string, []int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	src := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	index, err := lineIndex(src)
+	return src, index, err
+}
+
+// lineIndex maps each line of the block-free view of src to its index in src.
+func lineIndex(src []string) ([]int, error) {
+	blocks, err := parseReviewBlocks(src)
+	if err != nil {
+		return nil, err
+	}
+	var index []int
+	prev := 0
+	for _, b := range blocks {
+		for i := prev; i < b.start; i++ {
+			index = append(index, i)
+		}
+		first := b.sep + 1
+		if b.suggestion {
+			first = b.start + 1
+		}
+		for k := range b.anchor {
+			index = append(index, first+k)
+		}
+		prev = b.end + 1
+	}
+	for i := prev; i < len(src); i++ {
+		index = append(index, i)
+	}
+	return index, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteQuickfix(t *testing.T) {
+	// Line 2 already carries a block from an earlier run, which shifts line 3 down.
+	path := writeTemp(t, "a\n<<<<<<< REVIEW THREAD (1) thread=T1 comments=11\n2024-01-02 03:04 alice: why b?\n=======\n\tb\n>>>>>>> END REVIEW\n    c\n")
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := map[string]map[threadKey]*lineThread{path: {
+		{line: 3}: {line: 3, note: "outdated, relocated from L9", comments: []commentInfo{
+			{user: "bob", body: "reply", created: ts.Add(time.Hour)},
+			{user: "carol", body: "rename c\nand d", created: ts},
+		}},
+		{line: 2}: {line: 2, comments: []commentInfo{{user: "alice", body: "why b?", created: ts}}},
+	}}
+
+	var buf bytes.Buffer
+	writeQuickfix(&buf, threads)
+	want := path + ":5:2: [alice] why b?\n" +
+		path + ":7:5: [carol] rename c (outdated, relocated from L9)\n" +
+		"    and d\n" +
+		"    [bob] reply\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
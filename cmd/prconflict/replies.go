@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// pendingReply is a REPLY: typed into a review block, waiting to be posted.
+type pendingReply struct {
+	path     string
+	line     int    // 1-based line of the block header
+	threadID string // from the header; empty for blocks that only carry rootID
+	rootID   int64
+	body     string
+}
+
+// runPushReplies implements `prconflict push-replies [paths...]`: it posts the
+// REPLY: lines found in review blocks to their GitHub threads as one review.
+func runPushReplies(args []string) {
+	fset := flag.NewFlagSet("push-replies", flag.ExitOnError)
+	tmpl := addTemplateFlag(fset)
+	target := addPRFlags(fset)
+	dryRun := fset.Bool("dry-run", false, "Print replies instead of posting them")
+	submit := fset.Bool("submit", true, "Submit the review; false leaves it pending on GitHub")
+	fset.Parse(args)
+	useTemplate(*tmpl)
+
+	roots := fset.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	var replies []pendingReply
+	files := map[string][]string{}
+	err := forEachInjectedFile(roots, func(path string, src []string) {
+		blocks, err := parseReviewBlocks(src)
+		if err != nil {
+			log.Printf("%s: %v – skipping", path, err)
+			return
+		}
+		for _, b := range blocks {
+			if len(b.replies) == 0 {
+				continue
+			}
+			if len(b.threadIDs) == 0 && b.rootID == 0 {
+				log.Printf("%s:%d: block has no thread ID – rerun prconflict before replying", path, b.start+1)
+				continue
+			}
+			if len(b.threadIDs) > 1 {
+				log.Printf("%s:%d: block shows %d threads – replying to the first", path, b.start+1, len(b.threadIDs))
+			}
+			rp := pendingReply{
+				path:   path,
+				line:   b.start + 1,
+				rootID: b.rootID,
+				body:   strings.Join(b.replies, "\n"),
+			}
+			if len(b.threadIDs) > 0 {
+				rp.threadID = b.threadIDs[0]
+			}
+			replies = append(replies, rp)
+			files[path] = src
+		}
+	})
+	if err != nil {
+		log.Fatalf("push-replies: %v", err)
+	}
+	if len(replies) == 0 {
+		log.Println("No REPLY: lines found – nothing to post.")
+		return
+	}
+
+	if *dryRun {
+		for _, r := range replies {
+			to := r.threadID
+			if to == "" {
+				to = fmt.Sprintf("#%d", r.rootID)
+			}
+			fmt.Printf("%s:%d → %s\n%s\n\n", r.path, r.line, to, r.body)
+		}
+		return
+	}
+
+	owner, repo, prNumber, err := target.resolve()
+	if err != nil {
+		log.Fatalf("push-replies: %v", err)
+	}
+	ctx := context.Background()
+	_, ghQL, err := newClients(ctx)
+	if err != nil {
+		log.Fatalf("push-replies: %v", err)
+	}
+	resolver := NewGraphQLResolver(ghQL)
+
+	posted, postErr := postReplies(ctx, resolver, owner, repo, prNumber, replies, *submit)
+
+	// Posted replies are on GitHub now; drop them locally so a rerun does not post them twice.
+	done := map[string]map[int]bool{}
+	for _, rp := range posted {
+		if done[rp.path] == nil {
+			done[rp.path] = map[int]bool{}
+		}
+		done[rp.path][rp.line] = true
+	}
+	for path, src := range files {
+		if done[path] == nil {
+			continue
+		}
+		blocks, err := parseReviewBlocks(src)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			continue
+		}
+		var drop []reviewBlock
+		for _, b := range blocks {
+			if done[path][b.start+1] {
+				drop = append(drop, b)
+			}
+		}
+		if err := writeLines(path, stripReplies(src, drop)); err != nil {
+			log.Printf("%s: %v", path, err)
+		}
+	}
+	if postErr != nil {
+		for _, rp := range posted {
+			log.Printf("%s:%d: reply added to your pending review", rp.path, rp.line)
+		}
+		log.Fatalf("push-replies: %v – %d of %d replies posted", postErr, len(posted), len(replies))
+	}
+	state := "submitted"
+	if !*submit {
+		state = "left pending"
+	}
+	fmt.Printf("Posted %d replies in one review (%s).\n", len(replies), state)
+}
+
+// postReplies adds every reply to a single pending review and optionally submits it,
+// so reviewers receive one notification. The viewer's existing pending review is
+// reused, as GitHub allows only one. It returns the replies now on GitHub: if
+// posting fails, a review started here is deleted again and nothing is posted,
+// while replies added to a reused review stay there.
+func postReplies(ctx context.Context, r *GraphQLResolver, owner, repo string, prNumber int, replies []pendingReply, submit bool) ([]pendingReply, error) {
+	var threads map[int64]string
+	for i, rp := range replies {
+		if rp.threadID != "" {
+			continue
+		}
+		if threads == nil {
+			var err error
+			if threads, err = r.GetThreadIDsByComment(ctx, owner, repo, prNumber); err != nil {
+				return nil, err
+			}
+		}
+		id, ok := threads[rp.rootID]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: no review thread contains comment #%d", rp.path, rp.line, rp.rootID)
+		}
+		replies[i].threadID = id
+	}
+
+	reviewID, err := r.GetPendingReviewID(ctx, owner, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	started := reviewID == ""
+	if started {
+		prID, err := r.GetPullRequestID(ctx, owner, repo, prNumber)
+		if err != nil {
+			return nil, err
+		}
+		if reviewID, err = r.StartReview(ctx, prID); err != nil {
+			return nil, err
+		}
+	}
+	// abandon undoes a failed run as far as possible and reports what was posted.
+	abandon := func(posted []pendingReply, err error) ([]pendingReply, error) {
+		if !started {
+			return posted, err
+		}
+		if derr := r.DeleteReview(ctx, reviewID); derr != nil {
+			return posted, fmt.Errorf("%w; the pending review could not be deleted (%v) – delete it on GitHub", err, derr)
+		}
+		return nil, err
+	}
+
+	var posted []pendingReply
+	for _, rp := range replies {
+		if err := r.ReplyToThread(ctx, reviewID, rp.threadID, rp.body); err != nil {
+			return abandon(posted, fmt.Errorf("%s:%d: %w", rp.path, rp.line, err))
+		}
+		posted = append(posted, rp)
+	}
+	if !submit {
+		return posted, nil
+	}
+	if err := r.SubmitReview(ctx, reviewID); err != nil {
+		return abandon(posted, err)
+	}
+	return posted, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// fakeReviewAPI answers the GraphQL calls of postReplies and records the mutations.
+type fakeReviewAPI struct {
+	pending string   // ID of the viewer's pending review, if any
+	calls   []string // mutations in order, with the review they touched
+}
+
+func (f *fakeReviewAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Query     string
+		Variables struct {
+			Input map[string]any
+		}
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := body.Variables.Input
+	var data string
+	switch q := body.Query; {
+	case strings.Contains(q, "addPullRequestReviewThreadReply("):
+		f.calls = append(f.calls, "reply "+in["pullRequestReviewId"].(string))
+		if in["body"] == "fail" {
+			w.Write([]byte(`{"data":null,"errors":[{"message":"boom"}]}`))
+			return
+		}
+		data = `{"addPullRequestReviewThreadReply":{"comment":{"databaseId":1}}}`
+	case strings.Contains(q, "addPullRequestReview("):
+		f.calls = append(f.calls, "start")
+		data = `{"addPullRequestReview":{"pullRequestReview":{"id":"R_new"}}}`
+	case strings.Contains(q, "submitPullRequestReview("):
+		f.calls = append(f.calls, "submit "+in["pullRequestReviewId"].(string))
+		data = `{"submitPullRequestReview":{"pullRequestReview":{"state":"COMMENTED"}}}`
+	case strings.Contains(q, "deletePullRequestReview("):
+		f.calls = append(f.calls, "delete "+in["pullRequestReviewId"].(string))
+		data = `{"deletePullRequestReview":{"pullRequestReview":{"id":"R_new"}}}`
+	case strings.Contains(q, "reviews("):
+		nodes := "[]"
+		if f.pending != "" {
+			nodes = `[{"id":"` + f.pending + `","viewerDidAuthor":true}]`
+		}
+		data = `{"repository":{"pullRequest":{"reviews":{"nodes":` + nodes + `}}}}`
+	default:
+		data = `{"repository":{"pullRequest":{"id":"PR_1"}}}`
+	}
+	w.Write([]byte(`{"data":` + data + `}`))
+}
+
+func TestPostReplies(t *testing.T) {
+	replies := func(bodies ...string) []pendingReply {
+		var out []pendingReply
+		for i, b := range bodies {
+			out = append(out, pendingReply{path: "a.go", line: i + 1, threadID: "T", body: b})
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		pending string
+		bodies  []string
+		submit  bool
+		posted  int
+		wantErr bool
+		calls   []string
+	}{
+		{
+			name: "new review", bodies: []string{"ok", "ok"}, submit: true, posted: 2,
+			calls: []string{"start", "reply R_new", "reply R_new", "submit R_new"},
+		},
+		{
+			name: "failure deletes the new review", bodies: []string{"ok", "fail", "ok"}, submit: true, wantErr: true,
+			calls: []string{"start", "reply R_new", "reply R_new", "delete R_new"},
+		},
+		{
+			name: "pending review reused", pending: "R_old", bodies: []string{"ok"}, posted: 1,
+			calls: []string{"reply R_old"},
+		},
+		{
+			name: "failure keeps a reused review", pending: "R_old", bodies: []string{"ok", "fail"}, submit: true, posted: 1, wantErr: true,
+			calls: []string{"reply R_old", "reply R_old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeReviewAPI{pending: tt.pending}
+			srv := httptest.NewServer(api)
+			defer srv.Close()
+			r := NewGraphQLResolver(githubv4.NewEnterpriseClient(srv.URL, srv.Client()))
+
+			posted, err := postReplies(context.Background(), r, "o", "r", 1, replies(tt.bodies...), tt.submit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+			if len(posted) != tt.posted {
+				t.Errorf("posted %d replies, want %d", len(posted), tt.posted)
+			}
+			if !slices.Equal(api.calls, tt.calls) {
+				t.Errorf("calls = %v, want %v", api.calls, tt.calls)
+			}
+		})
+	}
+}
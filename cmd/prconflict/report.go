@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// reportVersion is bumped whenever a field of the JSON report changes meaning
+// or goes away; new fields may be added without a bump.
+const reportVersion = 1
+
+// Output formats of the main command besides the default, conflict blocks.
+var reportFormats = []string{"json", "sarif"}
+
+// report is the JSON document written by --format json.
+type report struct {
+	Version int            `json:"version"`
+	Repo    string         `json:"repo"` // owner/name
+	PR      int            `json:"pr"`
+	Threads []reportThread `json:"threads"`
+}
+
+// reportThread is one unresolved review thread. Lines refer to the PR head, or
+// to the commented commit when the thread is outdated.
+type reportThread struct {
+	ID         string          `json:"id"` // GraphQL node ID, as used by sync
+	Path       string          `json:"path"`
+	StartLine  int             `json:"startLine,omitempty"`
+	Line       int             `json:"line,omitempty"` // 0 for file-level threads
+	Side       string          `json:"side,omitempty"` // RIGHT, or LEFT for deleted lines
+	StartSide  string          `json:"startSide,omitempty"`
+	FileLevel  bool            `json:"fileLevel,omitempty"`
+	IsOutdated bool            `json:"isOutdated"`
+	CommitID   string          `json:"commitId"`
+	URL        string          `json:"url"`
+	Comments   []reportComment `json:"comments"`
+}
+
+type reportComment struct {
+	ID        int64     `json:"id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	URL       string    `json:"url"`
+}
+
+// collectThreads groups the comments of unresolved threads by thread, ordered
+// by path and line, each with its comments in chronological order. A thread's
+// position is that of its first comment. Comments by authors the trust policy
+// does not allow are left out and listed in untrusted, as planThreads does.
+func collectThreads(comments []*github.PullRequestComment, unresolved map[int64]string) (threads []reportThread, untrusted []string) {
+	byThread := map[string][]*github.PullRequestComment{}
+	var order []string
+	for _, c := range comments {
+		id, ok := unresolved[c.GetID()]
+		if !ok {
+			continue
+		}
+		if !trust.allows(c.GetAuthorAssociation()) {
+			untrusted = append(untrusted, untrustedComment(c))
+			continue
+		}
+		if byThread[id] == nil {
+			order = append(order, id)
+		}
+		byThread[id] = append(byThread[id], c)
+	}
+
+	threads = make([]reportThread, 0, len(order))
+	for _, id := range order {
+		cs := byThread[id]
+		sort.SliceStable(cs, func(i, j int) bool { return cs[i].GetCreatedAt().Before(cs[j].GetCreatedAt().Time) })
+		root := cs[0]
+		t := reportThread{
+			ID:         id,
+			Path:       root.GetPath(),
+			StartLine:  root.GetStartLine(),
+			Line:       root.GetLine(),
+			Side:       root.GetSide(),
+			StartSide:  root.GetStartSide(),
+			FileLevel:  root.GetSubjectType() == "file",
+			IsOutdated: root.Line == nil && root.GetSubjectType() != "file",
+			CommitID:   root.GetCommitID(),
+			URL:        root.GetHTMLURL(),
+		}
+		if t.IsOutdated {
+			t.StartLine, t.Line, t.CommitID = root.GetOriginalStartLine(), root.GetOriginalLine(), root.GetOriginalCommitID()
+		}
+		for _, c := range cs {
+			t.Comments = append(t.Comments, reportComment{
+				ID:        c.GetID(),
+				Author:    nonEmpty(c.GetUser().GetLogin()),
+				Body:      c.GetBody(),
+				CreatedAt: c.GetCreatedAt().Time,
+				UpdatedAt: c.GetUpdatedAt().Time,
+				URL:       c.GetHTMLURL(),
+			})
+		}
+		threads = append(threads, t)
+	}
+	sort.SliceStable(threads, func(i, j int) bool {
+		if threads[i].Path != threads[j].Path {
+			return threads[i].Path < threads[j].Path
+		}
+		return threads[i].Line < threads[j].Line
+	})
+	sort.Strings(untrusted)
+	return threads, untrusted
+}
+
+// writeReport writes threads to w in the given format instead of injecting them.
+func writeReport(w io.Writer, format, repo string, pr int, threads []reportThread) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report{Version: reportVersion, Repo: repo, PR: pr, Threads: threads})
+	case "sarif":
+		return writeSARIF(w, threads)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
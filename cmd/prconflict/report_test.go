@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestCollectThreads(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	comments := []*github.PullRequestComment{
+		{ID: github.Ptr(int64(3)), Path: github.Ptr("b.go"), Line: github.Ptr(7), Side: github.Ptr("RIGHT"),
+			User: &github.User{Login: github.Ptr("bob")}, Body: github.Ptr("reply"),
+			CreatedAt: &github.Timestamp{Time: ts.Add(time.Hour)}, HTMLURL: github.Ptr("u3")},
+		{ID: github.Ptr(int64(2)), Path: github.Ptr("b.go"), Line: github.Ptr(7), StartLine: github.Ptr(5), Side: github.Ptr("RIGHT"),
+			User: &github.User{Login: github.Ptr("alice")}, Body: github.Ptr("root"),
+			CreatedAt: &github.Timestamp{Time: ts}, HTMLURL: github.Ptr("u2")},
+		{ID: github.Ptr(int64(1)), Path: github.Ptr("a.go"), OriginalLine: github.Ptr(4), OriginalCommitID: github.Ptr("abc"),
+			Side: github.Ptr("RIGHT"), CreatedAt: &github.Timestamp{Time: ts}},
+		{ID: github.Ptr(int64(9)), Path: github.Ptr("a.go"), Line: github.Ptr(1)}, // resolved
+	}
+	threads, untrusted := collectThreads(comments, map[int64]string{1: "T1", 2: "T2", 3: "T2"})
+	if len(untrusted) != 0 {
+		t.Errorf("untrusted = %q, want none", untrusted)
+	}
+
+	if len(threads) != 2 {
+		t.Fatalf("got %d threads, want 2", len(threads))
+	}
+	a, b := threads[0], threads[1]
+	if a.ID != "T1" || !a.IsOutdated || a.Line != 4 || a.CommitID != "abc" {
+		t.Errorf("outdated thread = %+v", a)
+	}
+	if b.ID != "T2" || b.StartLine != 5 || b.Line != 7 || b.IsOutdated || b.URL != "u2" {
+		t.Errorf("range thread = %+v", b)
+	}
+	if len(b.Comments) != 2 || b.Comments[0].Author != "alice" || b.Comments[1].Body != "reply" {
+		t.Errorf("comments out of order: %+v", b.Comments)
+	}
+
+	var buf bytes.Buffer
+	if err := writeReport(&buf, "json", "o/r", 7, threads); err != nil {
+		t.Fatal(err)
+	}
+	var got report
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != reportVersion || got.Repo != "o/r" || got.PR != 7 || len(got.Threads) != 2 {
+		t.Errorf("report = %+v", got)
+	}
+}
+
+func TestCollectThreads_TrustPolicy(t *testing.T) {
+	defer func(p trustPolicy) { trust = p }(trust)
+	p, err := newTrustPolicy("OWNER,MEMBER", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust = p
+
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	comment := func(id int64, login, association string, d time.Duration) *github.PullRequestComment {
+		return &github.PullRequestComment{ID: github.Ptr(id), Path: github.Ptr("a.go"), Line: github.Ptr(int(id)),
+			User: &github.User{Login: github.Ptr(login)}, AuthorAssociation: github.Ptr(association),
+			Body: github.Ptr("text by " + login), CreatedAt: &github.Timestamp{Time: ts.Add(d)}, HTMLURL: github.Ptr("u" + login)}
+	}
+	comments := []*github.PullRequestComment{
+		comment(1, "mallory", "NONE", 0),
+		comment(2, "alice", "MEMBER", time.Hour),
+		comment(3, "eve", "CONTRIBUTOR", 0),
+	}
+	threads, untrusted := collectThreads(comments, map[int64]string{1: "T1", 2: "T1", 3: "T3"})
+	if len(threads) != 1 || threads[0].ID != "T1" || len(threads[0].Comments) != 1 || threads[0].Comments[0].Author != "alice" {
+		t.Fatalf("threads = %+v", threads)
+	}
+	want := []string{"a.go:1 by mallory (NONE) umallory", "a.go:3 by eve (CONTRIBUTOR) ueve"}
+	if len(untrusted) != 2 || untrusted[0] != want[0] || untrusted[1] != want[1] {
+		t.Errorf("untrusted = %q, want %q", untrusted, want)
+	}
+}
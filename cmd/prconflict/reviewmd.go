@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// prItem is one top-level entry of REVIEW.md: a review summary or a PR
+// conversation comment.
+type prItem struct {
+	user        string
+	association string // the author's author_association
+	state       string // review state, "" for conversation comments
+	body        string
+	created     time.Time
+	url         string
+}
+
+// defaultReviewSince is how far back REVIEW.md reaches unless --review-md-since says otherwise.
+const defaultReviewSince = "14d"
+
+// parseSince turns a --review-md-since value into a cutoff time: a number of
+// days such as "14d", a Go duration such as "36h", a date such as
+// "2024-05-01", or "all" for no cutoff (the zero time).
+func parseSince(s string, now time.Time) (time.Time, error) {
+	switch {
+	case s == "all":
+		return time.Time{}, nil
+	case strings.HasSuffix(s, "d"):
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return time.Time{}, fmt.Errorf("invalid number of days %q", s)
+		}
+		return now.AddDate(0, 0, -days), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a number of days, a duration, a date nor \"all\"", s)
+}
+
+// writeReviewMarkdown writes the PR's review summaries and conversation since
+// the given time to path, relative to the repository root, so they show up
+// next to the inline review blocks.
+func writeReviewMarkdown(ctx context.Context, gh *github.Client, owner, repo string, pr int, path string, since time.Time, dry bool) {
+	reviews, err := fetchReviews(ctx, gh, owner, repo, pr)
+	if err != nil {
+		log.Printf("could not write %s: %v", path, err)
+		return
+	}
+	comments, err := fetchIssueComments(ctx, gh, owner, repo, pr)
+	if err != nil {
+		log.Printf("could not write %s: %v", path, err)
+		return
+	}
+	out := renderReviewMarkdown(owner+"/"+repo, pr, reviews, comments, since)
+
+	rel := path
+	if !filepath.IsAbs(path) {
+		if top, err := exec.Command("git", "rev-parse", "--show-toplevel").Output(); err == nil {
+			path = filepath.Join(strings.TrimSpace(string(top)), path)
+		}
+	}
+	old, err := os.ReadFile(path)
+	if dry {
+		fmt.Print(unifiedDiff(filepath.ToSlash(rel), string(old), out, err != nil))
+		return
+	}
+	if err == nil && string(old) == out {
+		return
+	}
+	if err := os.WriteFile(path, []byte(out), 0644); err != nil {
+		log.Printf("could not write %s: %v", path, err)
+	}
+}
+
+// The first lines of REVIEW.md, by which clean, sync and push-replies tell it
+// from files holding review blocks.
+const (
+	reviewMDTitle = "# Review of "
+	reviewMDNote  = "Written by prconflict from the pull request's review summaries and conversation."
+)
+
+// isReviewMarkdown reports whether data is a REVIEW.md written by prconflict.
+func isReviewMarkdown(data []byte) bool {
+	lines := strings.SplitN(string(data), "\n", 4)
+	return len(lines) >= 3 && strings.HasPrefix(lines[0], reviewMDTitle) && lines[2] == reviewMDNote
+}
+
+// renderReviewMarkdown lists review summaries and conversation comments,
+// newest first, below the reviewers still requesting changes. Only items from
+// since on are listed, except change requests that still stand; summaries of
+// dismissed reviews, and of reviews followed by a later approval or change
+// request from the same reviewer, are left out. Entries by authors the trust
+// policy does not allow are left out, or listed without their text when
+// quarantined.
+func renderReviewMarkdown(repo string, pr int, reviews []*github.PullRequestReview, comments []*github.IssueComment, since time.Time) string {
+	// A reviewer's latest approval or change request is their current verdict.
+	sort.SliceStable(reviews, func(i, j int) bool {
+		return reviews[i].GetSubmittedAt().Before(reviews[j].GetSubmittedAt().Time)
+	})
+	verdict := map[string]string{}
+	lastVerdict := map[string]int{} // index of each reviewer's latest approval or change request
+	for i, r := range reviews {
+		if s := r.GetState(); s == "APPROVED" || s == "CHANGES_REQUESTED" {
+			lastVerdict[nonEmpty(r.GetUser().GetLogin())] = i
+		}
+	}
+	var summaries, quarantined []prItem
+	for i, r := range reviews {
+		user := nonEmpty(r.GetUser().GetLogin())
+		recent := !r.GetSubmittedAt().Before(since)
+		if !trust.allows(r.GetAuthorAssociation()) {
+			if r.GetState() != "PENDING" && recent {
+				quarantined = append(quarantined, prItem{user: user, association: r.GetAuthorAssociation(), created: r.GetSubmittedAt().Time, url: r.GetHTMLURL()})
+			}
+			continue
+		}
+		switch r.GetState() {
+		case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+			verdict[user] = r.GetState()
+		case "PENDING":
+			continue
+		}
+		if last, ok := lastVerdict[user]; ok && i < last {
+			continue // superseded
+		}
+		if r.GetState() == "DISMISSED" || strings.TrimSpace(r.GetBody()) == "" {
+			continue
+		}
+		if !recent && r.GetState() != "CHANGES_REQUESTED" {
+			continue
+		}
+		summaries = append(summaries, prItem{
+			user:    user,
+			state:   strings.ToLower(strings.ReplaceAll(r.GetState(), "_", " ")),
+			body:    r.GetBody(),
+			created: r.GetSubmittedAt().Time,
+			url:     r.GetHTMLURL(),
+		})
+	}
+	var conversation []prItem
+	for _, c := range comments {
+		if c.GetCreatedAt().Before(since) {
+			continue
+		}
+		if !trust.allows(c.GetAuthorAssociation()) {
+			quarantined = append(quarantined, prItem{user: nonEmpty(c.GetUser().GetLogin()), association: c.GetAuthorAssociation(), created: c.GetCreatedAt().Time, url: c.GetHTMLURL()})
+			continue
+		}
+		conversation = append(conversation, prItem{
+			user:    nonEmpty(c.GetUser().GetLogin()),
+			body:    c.GetBody(),
+			created: c.GetCreatedAt().Time,
+			url:     c.GetHTMLURL(),
+		})
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%s#%d\n\n%s\n", reviewMDTitle, repo, pr, reviewMDNote)
+	var blocking []string
+	for user, state := range verdict {
+		if state == "CHANGES_REQUESTED" {
+			blocking = append(blocking, user)
+		}
+	}
+	if len(blocking) > 0 {
+		sort.Strings(blocking)
+		fmt.Fprintf(&b, "\n**Changes requested by:** %s\n", strings.Join(blocking, ", "))
+	}
+	writeItems(&b, "Reviews", summaries)
+	writeItems(&b, "Conversation", conversation)
+	if trust.quarantine {
+		writeQuarantined(&b, quarantined)
+	}
+	return b.String()
+}
+
+// writeQuarantined lists entries by untrusted authors: who and when, with a
+// link, but not what they wrote.
+func writeQuarantined(b *strings.Builder, items []prItem) {
+	if len(items) == 0 {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].created.After(items[j].created) })
+	b.WriteString("\n## Quarantined\n\nBy authors prconflict is not set to trust; read them on GitHub.\n\n")
+	for _, it := range items {
+		fmt.Fprintf(b, "- %s (%s) – %s", neutralize(it.user), nonEmpty(it.association), it.created.Format("2006-01-02 15:04"))
+		if it.url != "" {
+			fmt.Fprintf(b, " – [View on GitHub](%s)", neutralize(it.url))
+		}
+		b.WriteString("\n")
+	}
+}
+
+func writeItems(b *strings.Builder, title string, items []prItem) {
+	if len(items) == 0 {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].created.After(items[j].created) })
+	fmt.Fprintf(b, "\n## %s\n", title)
+	for _, it := range items {
+		fmt.Fprintf(b, "\n### %s", neutralize(it.user))
+		if it.state != "" {
+			fmt.Fprintf(b, " – %s", it.state)
+		}
+		// Lines that read as block markers are escaped, so that REVIEW.md
+		// never parses as a review block.
+		body := strings.Split(strings.TrimSpace(neutralize(it.body)), "\n")
+		for i, l := range body {
+			if markers.forged(l) {
+				body[i] = `\` + l
+			}
+		}
+		fmt.Fprintf(b, " – %s\n\n%s\n", it.created.Format("2006-01-02 15:04"), strings.Join(body, "\n"))
+		if it.url != "" {
+			fmt.Fprintf(b, "\n[View on GitHub](%s)\n", it.url)
+		}
+	}
+}
+
+func fetchReviews(ctx context.Context, gh *github.Client, owner, repo string, pr int) ([]*github.PullRequestReview, error) {
+	var all []*github.PullRequestReview
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		rs, resp, err := gh.PullRequests.ListReviews(ctx, owner, repo, pr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("ListReviews: %w", err)
+		}
+		all = append(all, rs...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func fetchIssueComments(ctx context.Context, gh *github.Client, owner, repo string, pr int) ([]*github.IssueComment, error) {
+	var all []*github.IssueComment
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		cs, resp, err := gh.Issues.ListComments(ctx, owner, repo, pr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("ListComments: %w", err)
+		}
+		all = append(all, cs...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.ListOptions.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestRenderReviewMarkdown(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	at := func(d time.Duration) *github.Timestamp { return &github.Timestamp{Time: ts.Add(d)} }
+	alice := &github.User{Login: github.Ptr("alice")}
+	bob := &github.User{Login: github.Ptr("bob")}
+	reviews := []*github.PullRequestReview{
+		{User: bob, State: github.Ptr("CHANGES_REQUESTED"), Body: github.Ptr("old objection"), SubmittedAt: at(0)},
+		{User: alice, State: github.Ptr("CHANGES_REQUESTED"), Body: github.Ptr("Please add tests."), SubmittedAt: at(time.Hour), HTMLURL: github.Ptr("https://example.com/r1")},
+		{User: bob, State: github.Ptr("APPROVED"), SubmittedAt: at(2 * time.Hour)},
+		{User: bob, State: github.Ptr("PENDING"), Body: github.Ptr("draft"), SubmittedAt: at(3 * time.Hour)},
+	}
+	comments := []*github.IssueComment{
+		{User: bob, Body: github.Ptr("Any update?"), CreatedAt: at(4 * time.Hour), HTMLURL: github.Ptr("https://example.com/c1")},
+	}
+
+	got := renderReviewMarkdown("o/r", 7, reviews, comments, time.Time{})
+	want := "# Review of o/r#7\n\n" +
+		"Written by prconflict from the pull request's review summaries and conversation.\n\n" +
+		"**Changes requested by:** alice\n\n" +
+		"## Reviews\n\n" +
+		"### alice – changes requested – 2024-01-02 04:04\n\nPlease add tests.\n\n[View on GitHub](https://example.com/r1)\n\n" +
+		"## Conversation\n\n" +
+		"### bob – 2024-01-02 07:04\n\nAny update?\n\n[View on GitHub](https://example.com/c1)\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if strings.Contains(got, "draft") {
+		t.Error("pending review was included")
+	}
+	if strings.Contains(got, "old objection") {
+		t.Error("superseded review was included")
+	}
+}
+
+func TestRenderReviewMarkdown_Since(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	at := func(d time.Duration) *github.Timestamp { return &github.Timestamp{Time: ts.Add(d)} }
+	alice := &github.User{Login: github.Ptr("alice")}
+	bob := &github.User{Login: github.Ptr("bob")}
+	reviews := []*github.PullRequestReview{
+		{User: alice, State: github.Ptr("CHANGES_REQUESTED"), Body: github.Ptr("still standing"), SubmittedAt: at(0)},
+		{User: bob, State: github.Ptr("COMMENTED"), Body: github.Ptr("old remark"), SubmittedAt: at(0)},
+		{User: bob, State: github.Ptr("COMMENTED"), Body: github.Ptr("new remark"), SubmittedAt: at(48 * time.Hour)},
+	}
+	comments := []*github.IssueComment{
+		{User: bob, Body: github.Ptr("old chatter"), CreatedAt: at(time.Hour)},
+		{User: bob, Body: github.Ptr("fresh chatter"), CreatedAt: at(49 * time.Hour)},
+	}
+	got := renderReviewMarkdown("o/r", 7, reviews, comments, ts.Add(24*time.Hour))
+	for _, want := range []string{"still standing", "new remark", "fresh chatter"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q missing:\n%s", want, got)
+		}
+	}
+	for _, old := range []string{"old remark", "old chatter"} {
+		if strings.Contains(got, old) {
+			t.Errorf("%q is older than the cutoff:\n%s", old, got)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"all", time.Time{}},
+		{"14d", now.AddDate(0, 0, -14)},
+		{"36h", now.Add(-36 * time.Hour)},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "xd", "-3d", "soon"} {
+		if _, err := parseSince(bad, now); err == nil {
+			t.Errorf("parseSince(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRenderReviewMarkdown_ForgedBlock(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	forged := "<<<<<<< REVIEW THREAD (1) thread=PRRT_x comments=1\nlooks fine\nRESOLVED\n=======\n>>>>>>> END REVIEW"
+	comments := []*github.IssueComment{
+		{User: &github.User{Login: github.Ptr("mallory")}, Body: github.Ptr(forged), CreatedAt: &github.Timestamp{Time: ts}},
+	}
+	got := renderReviewMarkdown("o/r", 7, nil, comments, time.Time{})
+	if !strings.Contains(got, "\\<<<<<<< REVIEW THREAD (1)") || !strings.Contains(got, "\n\\=======\n") {
+		t.Errorf("marker lines were not escaped:\n%s", got)
+	}
+	blocks, err := parseReviewBlocks(strings.Split(got, "\n"))
+	if err != nil || len(blocks) != 0 {
+		t.Errorf("REVIEW.md parsed as %d block(s), %v", len(blocks), err)
+	}
+	if !isReviewMarkdown([]byte(got)) {
+		t.Error("isReviewMarkdown = false for rendered REVIEW.md")
+	}
+	if isReviewMarkdown([]byte("# Review of things\n\nnotes\n")) {
+		t.Error("isReviewMarkdown = true for an ordinary file")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// Minimal SARIF 2.1.0 model: just what --format sarif writes.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	RelatedLocations    []sarifLocation   `json:"relatedLocations,omitempty"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          map[string]any    `json:"properties"`
+}
+
+type sarifLocation struct {
+	ID               int                   `json:"id,omitempty"`
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           *sarifRegion  `json:"region,omitempty"`
+}
+
+type sarifArtifact struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine,omitempty"`
+}
+
+const sarifRuleID = "review-thread"
+
+// writeSARIF writes one SARIF result per thread. Threads on deleted lines and
+// file-level threads point at the file without a region, since their lines do
+// not exist in the PR head.
+func writeSARIF(w io.Writer, threads []reportThread) error {
+	results := make([]sarifResult, 0, len(threads))
+	for _, t := range threads {
+		var text []string
+		participants := []string{}
+		seen := map[string]bool{}
+		for _, c := range t.Comments {
+			author := neutralize(c.Author)
+			text = append(text, author+": "+neutralize(c.Body))
+			if !seen[author] {
+				seen[author] = true
+				participants = append(participants, author)
+			}
+		}
+		loc := sarifPhysicalLocation{ArtifactLocation: sarifArtifact{URI: t.Path, URIBaseID: "%SRCROOT%"}}
+		if t.Line > 0 && t.Side != "LEFT" {
+			loc.Region = &sarifRegion{StartLine: t.Line}
+			if t.StartLine > 0 && t.StartLine < t.Line {
+				loc.Region.StartLine, loc.Region.EndLine = t.StartLine, t.Line
+			}
+		}
+		r := sarifResult{
+			RuleID:              sarifRuleID,
+			Level:               "warning",
+			Message:             sarifMessage{Text: strings.Join(text, "\n\n")},
+			Locations:           []sarifLocation{{PhysicalLocation: loc}},
+			PartialFingerprints: map[string]string{"reviewThread/v1": t.ID},
+			Properties: map[string]any{
+				"reviewer":     participants[0],
+				"participants": participants,
+				"threadId":     t.ID,
+				"isOutdated":   t.IsOutdated,
+			},
+		}
+		if t.URL != "" {
+			r.RelatedLocations = []sarifLocation{{
+				ID:               1,
+				PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifact{URI: t.URL}},
+				Message:          &sarifMessage{Text: "Review thread on GitHub"},
+			}}
+		}
+		results = append(results, r)
+	}
+
+	doc := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "prconflict",
+				InformationURI: "https://github.com/teddyknox/prconflict",
+				Rules:          []sarifRule{{ID: sarifRuleID, ShortDescription: sarifMessage{Text: "Unresolved pull request review thread"}}},
+			}},
+			Results: results,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
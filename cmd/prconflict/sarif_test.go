@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteSARIF(t *testing.T) {
+	threads := []reportThread{
+		{ID: "T1", Path: "a.go", StartLine: 3, Line: 5, Side: "RIGHT", URL: "https://example.com/t1",
+			Comments: []reportComment{{Author: "alice", Body: "rename"}, {Author: "bob", Body: "+1\x1b[2J\u202e"}}},
+		{ID: "T2", Path: "b.go", Line: 4, Side: "LEFT", Comments: []reportComment{{Author: "carol", Body: "why?"}}},
+	}
+	var buf bytes.Buffer
+	if err := writeSARIF(&buf, threads); err != nil {
+		t.Fatal(err)
+	}
+	var doc sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "2.1.0" || len(doc.Runs) != 1 || len(doc.Runs[0].Results) != 2 {
+		t.Fatalf("unexpected document: %s", buf.String())
+	}
+
+	r := doc.Runs[0].Results[0]
+	loc := r.Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "a.go" || loc.Region == nil || loc.Region.StartLine != 3 || loc.Region.EndLine != 5 {
+		t.Errorf("location = %+v", loc)
+	}
+	if r.Properties["reviewer"] != "alice" || r.Message.Text != "alice: rename\n\nbob: +1<U+001B>[2J<U+202E>" {
+		t.Errorf("result = %+v", r)
+	}
+	if len(r.RelatedLocations) != 1 || r.RelatedLocations[0].PhysicalLocation.ArtifactLocation.URI != "https://example.com/t1" {
+		t.Errorf("related locations = %+v", r.RelatedLocations)
+	}
+	if region := doc.Runs[0].Results[1].Locations[0].PhysicalLocation.Region; region != nil {
+		t.Errorf("deleted-line thread got region %+v", region)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "strings"
+
+// parseSuggestion extracts the replacement lines of the first ```suggestion
+// fence in a comment body. ok is false when the body has no suggestion.
+func parseSuggestion(body string) (lines []string, ok bool) {
+	inside := false
+	for _, l := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(l)
+		if !inside {
+			if strings.HasPrefix(trimmed, "```suggestion") {
+				inside, ok = true, true
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") {
+			return lines, true
+		}
+		lines = append(lines, l)
+	}
+	return lines, ok
+}
+
+// withoutSuggestion replaces suggestion fences in body with a short note, since
+// the suggested code is rendered as its own side of the conflict.
+func withoutSuggestion(body string) string {
+	var out []string
+	inside := false
+	for _, l := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(l)
+		switch {
+		case !inside && strings.HasPrefix(trimmed, "```suggestion"):
+			inside = true
+			out = append(out, "[suggestion]")
+		case inside && strings.HasPrefix(trimmed, "```"):
+			inside = false
+		case !inside:
+			out = append(out, l)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// threadSuggestion returns the latest suggestion made in a thread's comments.
+func threadSuggestion(cs []commentInfo) ([]string, bool) {
+	for i := len(cs) - 1; i >= 0; i-- {
+		if lines, ok := parseSuggestion(cs[i].body); ok {
+			return lines, true
+		}
+	}
+	return nil, false
+}
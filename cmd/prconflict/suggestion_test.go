@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSuggestion(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+		ok   bool
+	}{
+		{"plain comment", nil, false},
+		{"Try this:\n```suggestion\n\treturn nil\n```", []string{"\treturn nil"}, true},
+		{"```suggestion\r\na\r\nb\r\n```\r\nthanks", []string{"a", "b"}, true},
+		{"Delete it:\n```suggestion\n```", nil, true},
+		{"```go\nnot a suggestion\n```", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSuggestion(tt.body)
+		if ok != tt.ok || strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("parseSuggestion(%q) = %q, %v; want %q, %v", tt.body, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestInjectThreads_MultiLineSuggestion(t *testing.T) {
+	orig := "func f() {\n\tx := 1\n\ty := 2\n\treturn x + y\n}\n"
+	path := writeTemp(t, orig)
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	threads := []lineThread{{
+		line:  3,
+		start: 2,
+		comments: []commentInfo{{
+			id: 5, threadID: "T5", user: "alice", created: ts,
+			body: "Combine these:\n```suggestion\n\tx, y := 1, 2\n```",
+		}},
+	}}
+
+	if _, err := injectThreads(path, threads, &threadSet{open: map[string]bool{"T5": true}}, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, path)
+	want := "func f() {\n" +
+		"<<<<<<< REVIEW THREAD (1) suggestion thread=T5 comments=5 sum=3a0a927c\n" +
+		"\tx := 1\n\ty := 2\n" +
+		"||||||| REVIEW\n" +
+		"2024-01-02 03:04 alice: Combine these:\n" +
+		"    [suggestion]\n" +
+		"=======\n" +
+		"\tx, y := 1, 2\n" +
+		">>>>>>> END REVIEW\n" +
+		"\treturn x + y\n}\n"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	blocks, err := parseReviewBlocks(strings.Split(got, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || !blocks[0].suggestion || len(blocks[0].anchor) != 2 || len(blocks[0].suggested) != 1 {
+		t.Fatalf("unexpected parse: %+v", blocks)
+	}
+
+	// Rerunning leaves the block as is; clean restores the current code.
+	if _, err := injectThreads(path, threads, &threadSet{open: map[string]bool{"T5": true}}, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if again := readFile(t, path); again != got {
+		t.Errorf("rerun changed the file:\n%s", again)
+	}
+	out, n, err := stripReviewBlocks(strings.Split(got, "\n"))
+	if err != nil || n != 1 || strings.Join(out, "\n") != orig {
+		t.Errorf("clean = %q, %d, %v", strings.Join(out, "\n"), n, err)
+	}
+}
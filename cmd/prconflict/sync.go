@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// injectedThread records one review thread written into the working tree.
+type injectedThread struct {
+	ThreadID string `json:"threadId"`
+	BlockID  int64  `json:"blockId"` // root comment ID shown in the block header
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+}
+
+// syncState is persisted inside the git directory between runs.
+type syncState struct {
+	Repo    string           `json:"repo"`
+	PR      int              `json:"pr"`
+	Threads []injectedThread `json:"threads"`
+}
+
+const stateFile = "prconflict.json"
+
+// injectedFromThreads lists the review threads contained in the blocks placed in path.
+func injectedFromThreads(path string, placed []lineThread) []injectedThread {
+	var out []injectedThread
+	for _, th := range placed {
+		if len(th.comments) == 0 {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, c := range th.comments {
+			if c.threadID == "" || seen[c.threadID] {
+				continue
+			}
+			seen[c.threadID] = true
+			out = append(out, injectedThread{
+				ThreadID: c.threadID,
+				BlockID:  th.comments[0].id,
+				Path:     path,
+				Line:     th.line,
+			})
+		}
+	}
+	return out
+}
+
+// blockKeys lists the identifiers a block answers to: its thread IDs and its
+// root comment ID, which is all that blocks from before thread IDs carry.
+func blockKeys(b reviewBlock) []string {
+	keys := append([]string(nil), b.threadIDs...)
+	if b.rootID != 0 {
+		keys = append(keys, "#"+strconv.FormatInt(b.rootID, 10))
+	}
+	return keys
+}
+
+// keys lists the identifiers under which t's block may appear (see blockKeys).
+func (t injectedThread) keys() []string {
+	return []string{t.ThreadID, "#" + strconv.FormatInt(t.BlockID, 10)}
+}
+
+// anyKey reports whether set contains one of keys.
+func anyKey[V any](set map[string]V, keys []string) bool {
+	for _, k := range keys {
+		if _, ok := set[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// statePath returns the location of the sync state inside the git directory.
+func statePath() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--git-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("not inside a git repository: %w", err)
+	}
+	return filepath.Join(strings.TrimSpace(string(out)), stateFile), nil
+}
+
+// loadState reads the sync state. A missing file yields an empty state.
+func loadState() (syncState, error) {
+	var st syncState
+	path, err := statePath()
+	if err != nil {
+		return st, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("%s: %w", path, err)
+	}
+	return st, nil
+}
+
+func saveState(st syncState) error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// recordInjected replaces the sync state with the threads now present in the
+// working tree for repo#pr.
+func recordInjected(repo string, pr int, threads []injectedThread) error {
+	return saveState(syncState{Repo: repo, PR: pr, Threads: threads})
+}
+
+// recordedThreads returns the keys (see injectedThread.keys) of the threads in
+// the sync state; it is empty outside a repository or before the first run.
+func recordedThreads() map[string]bool {
+	keys := map[string]bool{}
+	if _, err := statePath(); err != nil {
+		return keys
+	}
+	st, err := loadState()
+	if err != nil {
+		log.Printf("could not read sync state: %v", err)
+		return keys
+	}
+	for _, t := range st.Threads {
+		for _, k := range t.keys() {
+			keys[k] = true
+		}
+	}
+	return keys
+}
+
+// forgetThreads drops the threads of blocks from the sync state, so that sync
+// does not resolve threads whose blocks were removed by clean.
+func forgetThreads(blocks []reviewBlock) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+	if _, err := statePath(); err != nil {
+		return nil // outside a repository there is no state to update
+	}
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	gone := map[string]bool{}
+	for _, b := range blocks {
+		for _, k := range blockKeys(b) {
+			gone[k] = true
+		}
+	}
+	var keep []injectedThread
+	for _, t := range st.Threads {
+		if !anyKey(gone, t.keys()) {
+			keep = append(keep, t)
+		}
+	}
+	if len(keep) == len(st.Threads) {
+		return nil
+	}
+	st.Threads = keep
+	return saveState(st)
+}
+
+// checkPR reports an error unless the state was recorded for owner/repo#pr.
+func (st syncState) checkPR(owner, repo string, pr int) error {
+	if !strings.EqualFold(st.Repo, owner+"/"+repo) || st.PR != pr {
+		return fmt.Errorf("recorded threads belong to %s#%d, not %s/%s#%d – run prconflict for this PR first", st.Repo, st.PR, owner, repo, pr)
+	}
+	return nil
+}
+
+// syncScan is what sync saw of the working tree.
+type syncScan struct {
+	top            string          // repository root; scanned paths are made relative to it
+	roots          []string        // scanned roots, relative to the repository root
+	present        map[string]bool // block keys still in the tree
+	resolved       map[string]bool // block keys marked RESOLVED
+	pendingReplies map[string]bool // block keys with unposted REPLY lines
+	unparsed       map[string]bool // files that could not be parsed, relative to the repository root
+}
+
+// newSyncScan prepares a scan of roots, given relative to the current directory.
+func newSyncScan(roots []string) (*syncScan, error) {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, fmt.Errorf("not inside a git repository: %w", err)
+	}
+	s := &syncScan{
+		top:            strings.TrimSpace(string(out)),
+		present:        map[string]bool{},
+		resolved:       map[string]bool{},
+		pendingReplies: map[string]bool{},
+		unparsed:       map[string]bool{},
+	}
+	for _, r := range roots {
+		s.roots = append(s.roots, s.repoPath(r))
+	}
+	return s, nil
+}
+
+// repoPath turns a path relative to the current directory into one relative to
+// the repository root, as recorded in the sync state.
+func (s *syncScan) repoPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(filepath.Clean(path))
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = real
+	}
+	top := s.top
+	if real, err := filepath.EvalSymlinks(top); err == nil {
+		top = real
+	}
+	rel, err := filepath.Rel(top, abs)
+	if err != nil {
+		return filepath.ToSlash(filepath.Clean(path))
+	}
+	return filepath.ToSlash(rel)
+}
+
+// add records a block found in the tree.
+func (s *syncScan) add(b reviewBlock) {
+	for _, id := range blockKeys(b) {
+		s.present[id] = true
+		if b.resolved {
+			s.resolved[id] = true
+		}
+		if len(b.replies) > 0 {
+			s.pendingReplies[id] = true
+		}
+	}
+}
+
+// covers reports whether path, relative to the repository root, was scanned.
+func (s *syncScan) covers(path string) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	for _, r := range s.roots {
+		if r == "." || path == r || strings.HasPrefix(path, r+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// decide splits the recorded threads into those to resolve – their block was
+// marked RESOLVED or deleted – and those to keep. Threads outside the scanned
+// roots or in files that could not be parsed are kept: their blocks were not seen.
+func (s *syncScan) decide(threads []injectedThread) (toResolve, keep []injectedThread) {
+	for _, t := range threads {
+		marked := anyKey(s.resolved, t.keys())
+		switch {
+		case !s.covers(t.Path) || s.unparsed[filepath.ToSlash(filepath.Clean(t.Path))]:
+			keep = append(keep, t)
+		case marked && anyKey(s.pendingReplies, t.keys()):
+			log.Printf("%s:%d: block has unposted REPLY lines – run push-replies first", t.Path, t.Line)
+			keep = append(keep, t)
+		case marked || !anyKey(s.present, t.keys()):
+			toResolve = append(toResolve, t)
+		default:
+			keep = append(keep, t)
+		}
+	}
+	return toResolve, keep
+}
+
+// runSync implements `prconflict sync [paths...]`: threads whose block was
+// deleted or marked RESOLVED in the working tree are resolved on GitHub.
+func runSync(args []string) {
+	fset := flag.NewFlagSet("sync", flag.ExitOnError)
+	tmpl := addTemplateFlag(fset)
+	target := addPRFlags(fset)
+	dryRun := fset.Bool("dry-run", false, "List threads that would be resolved without calling GitHub")
+	fset.Parse(args)
+	useTemplate(*tmpl)
+
+	roots := fset.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	st, err := loadState()
+	if err != nil {
+		log.Fatalf("sync: %v", err)
+	}
+	if len(st.Threads) == 0 {
+		log.Println("No injected threads recorded – run prconflict first.")
+		return
+	}
+	owner, repo, prNumber, err := target.resolve()
+	if err != nil {
+		log.Fatalf("sync: %v", err)
+	}
+	if err := st.checkPR(owner, repo, prNumber); err != nil {
+		log.Fatalf("sync: %v", err)
+	}
+
+	scan, err := newSyncScan(roots)
+	if err != nil {
+		log.Fatalf("sync: %v", err)
+	}
+	files := map[string][]string{}
+	err = forEachInjectedFile(roots, func(path string, src []string) {
+		blocks, err := parseReviewBlocks(src)
+		if err != nil {
+			log.Printf("%s: %v – skipping, its threads stay open", path, err)
+			scan.unparsed[scan.repoPath(path)] = true
+			return
+		}
+		for _, b := range blocks {
+			scan.add(b)
+			if b.resolved {
+				files[path] = src
+			}
+		}
+	})
+	if err != nil {
+		log.Fatalf("sync: %v", err)
+	}
+
+	toResolve, keep := scan.decide(st.Threads)
+	if len(toResolve) == 0 {
+		log.Println("No deleted or RESOLVED blocks – nothing to sync.")
+		return
+	}
+
+	if *dryRun {
+		for _, t := range toResolve {
+			fmt.Printf("would resolve %s (%s:%d)\n", t.ThreadID, t.Path, t.Line)
+		}
+		return
+	}
+
+	ctx := context.Background()
+	_, ghQL, err := newClients(ctx)
+	if err != nil {
+		log.Fatalf("sync: %v", err)
+	}
+	resolver := NewGraphQLResolver(ghQL)
+
+	done := map[string]bool{}
+	for _, t := range toResolve {
+		if err := resolver.ResolveThread(ctx, t.ThreadID); err != nil {
+			log.Printf("%s:%d: %v", t.Path, t.Line, err)
+			keep = append(keep, t)
+			continue
+		}
+		for _, k := range t.keys() {
+			done[k] = true
+		}
+		fmt.Printf("resolved %s (%s:%d)\n", t.ThreadID, t.Path, t.Line)
+	}
+
+	// Blocks marked RESOLVED have served their purpose; put the source back.
+	for path, src := range files {
+		blocks, err := parseReviewBlocks(src)
+		if err != nil {
+			log.Printf("%s: %v", path, err)
+			continue
+		}
+		var drop []reviewBlock
+		for _, b := range blocks {
+			if !b.resolved {
+				continue
+			}
+			if anyKey(done, blockKeys(b)) {
+				drop = append(drop, b)
+			}
+		}
+		if len(drop) == 0 {
+			continue
+		}
+		if err := writeLines(path, removeBlocks(src, drop)); err != nil {
+			log.Printf("%s: %v", path, err)
+		}
+	}
+
+	st.Threads = keep
+	if err := saveState(st); err != nil {
+		log.Printf("could not save sync state: %v", err)
+	}
+}
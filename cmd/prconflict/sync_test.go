@@ -0,0 +1,121 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInjectedFromThreads(t *testing.T) {
+	placed := []lineThread{
+		{line: 10, comments: []commentInfo{
+			{id: 1, threadID: "PRRT_a"},
+			{id: 2, threadID: "PRRT_a"},
+			{id: 3, threadID: "PRRT_b"},
+		}},
+		{line: 3, comments: []commentInfo{{id: 9, threadID: "PRRT_c"}}},
+	}
+	got := injectedFromThreads("main.go", placed)
+	want := []injectedThread{
+		{ThreadID: "PRRT_a", BlockID: 1, Path: "main.go", Line: 10},
+		{ThreadID: "PRRT_b", BlockID: 1, Path: "main.go", Line: 10},
+		{ThreadID: "PRRT_c", BlockID: 9, Path: "main.go", Line: 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d threads, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("thread[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSyncScanDecide(t *testing.T) {
+	s := &syncScan{
+		roots:          []string{"src"},
+		present:        map[string]bool{},
+		resolved:       map[string]bool{},
+		pendingReplies: map[string]bool{},
+		unparsed:       map[string]bool{"src/broken.go": true},
+	}
+	s.add(reviewBlock{threadIDs: []string{"PRRT_kept"}})
+	s.add(reviewBlock{threadIDs: []string{"PRRT_done"}, resolved: true})
+	s.add(reviewBlock{threadIDs: []string{"PRRT_reply"}, resolved: true, replies: []string{"on it"}})
+	threads := []injectedThread{
+		{ThreadID: "PRRT_kept", BlockID: 1, Path: "src/a.go"},
+		{ThreadID: "PRRT_done", BlockID: 2, Path: "src/a.go"},
+		{ThreadID: "PRRT_reply", BlockID: 3, Path: "src/a.go"},
+		{ThreadID: "PRRT_gone", BlockID: 4, Path: "src/sub/b.go"},
+		{ThreadID: "PRRT_broken", BlockID: 5, Path: "src/broken.go"},
+		{ThreadID: "PRRT_elsewhere", BlockID: 6, Path: "docs/c.md"},
+		{ThreadID: "PRRT_prefix", BlockID: 7, Path: "srcs/d.go"},
+	}
+	toResolve, keep := s.decide(threads)
+	ids := func(ts []injectedThread) []string {
+		var out []string
+		for _, t := range ts {
+			out = append(out, t.ThreadID)
+		}
+		return out
+	}
+	if got, want := ids(toResolve), []string{"PRRT_done", "PRRT_gone"}; !slices.Equal(got, want) {
+		t.Errorf("toResolve = %v, want %v", got, want)
+	}
+	if got, want := ids(keep), []string{"PRRT_kept", "PRRT_reply", "PRRT_broken", "PRRT_elsewhere", "PRRT_prefix"}; !slices.Equal(got, want) {
+		t.Errorf("keep = %v, want %v", got, want)
+	}
+
+	s.roots = []string{"."}
+	if toResolve, _ := s.decide(threads); len(toResolve) != 4 {
+		t.Errorf("whole tree: toResolve = %v, want done, gone, elsewhere and prefix", ids(toResolve))
+	}
+}
+
+func TestCleanThenSync(t *testing.T) {
+	gitRepo(t, "a.go", "package a\n\nfunc A() {}\n")
+	threads := []lineThread{{line: 3, comments: []commentInfo{{id: 1, threadID: "PRRT_a", user: "bob", body: "doc?", created: time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)}}}}
+	if _, err := injectThreads("a.go", threads, nil, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordInjected("o/r", 1, injectedFromThreads("a.go", threads)); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, _, err := cleanFile("a.go", strings.Split(readFile(t, "a.go"), "\n"), nil, false)
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("cleanFile removed %d blocks, %v", len(removed), err)
+	}
+	if err := forgetThreads(removed); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Repo != "o/r" || st.PR != 1 || len(st.Threads) != 0 {
+		t.Fatalf("state after clean = %+v, want o/r#1 without threads", st)
+	}
+	scan, err := newSyncScan([]string{"."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toResolve, _ := scan.decide(st.Threads); len(toResolve) != 0 {
+		t.Errorf("sync would resolve cleaned threads: %+v", toResolve)
+	}
+}
+
+func TestSyncStateCheckPR(t *testing.T) {
+	st := syncState{Repo: "Owner/Repo", PR: 7}
+	if err := st.checkPR("owner", "repo", 7); err != nil {
+		t.Errorf("same PR: %v", err)
+	}
+	if err := st.checkPR("owner", "repo", 8); err == nil {
+		t.Error("expected error for another PR")
+	}
+	if err := st.checkPR("owner", "fork", 7); err == nil {
+		t.Error("expected error for another repository")
+	}
+}
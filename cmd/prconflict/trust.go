@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// authorAssociations are the relations to the repository GitHub reports for a
+// comment's author (author_association in REST, authorAssociation in GraphQL).
+var authorAssociations = []string{"OWNER", "MEMBER", "COLLABORATOR", "CONTRIBUTOR", "FIRST_TIME_CONTRIBUTOR", "FIRST_TIMER", "MANNEQUIN", "NONE"}
+
+// trustPolicy decides whose comments are written into the working tree.
+type trustPolicy struct {
+	trusted    map[string]bool // author associations to trust; nil trusts everyone
+	quarantine bool            // list untrusted comments, without their text
+}
+
+// trust is the policy in use.
+var trust = trustPolicy{quarantine: true}
+
+// allows reports whether comments by an author with the given association are used.
+func (p trustPolicy) allows(association string) bool {
+	return p.trusted == nil || p.trusted[strings.ToUpper(association)]
+}
+
+// trustFlags holds the flags of every command that writes or applies comments.
+type trustFlags struct {
+	trust     *string
+	untrusted *string
+}
+
+func addTrustFlags(fs *flag.FlagSet) trustFlags {
+	return trustFlags{
+		trust:     fs.String("trust", "", "Author associations whose comments are used, e.g. OWNER,MEMBER,COLLABORATOR, or all (default git config prconflict.trust, else all)"),
+		untrusted: fs.String("untrusted", "", "Other comments are listed without their text (quarantine) or only counted (skip) (default git config prconflict.untrusted, else quarantine)"),
+	}
+}
+
+// use switches to the policy given by the flags, falling back to the
+// repository's git config. Call it from inside the repository.
+func (f trustFlags) use() {
+	p, err := f.policy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	trust = p
+}
+
+// policy returns the policy given by the flags, falling back to the
+// repository's git config.
+func (f trustFlags) policy() (trustPolicy, error) {
+	list, mode := *f.trust, *f.untrusted
+	if list == "" {
+		list = gitConfig("prconflict.trust")
+	}
+	if mode == "" {
+		mode = gitConfig("prconflict.untrusted")
+	}
+	p, err := newTrustPolicy(list, mode)
+	if err != nil {
+		return p, fmt.Errorf("trust policy: %w", err)
+	}
+	return p, nil
+}
+
+// newTrustPolicy parses a comma-separated list of author associations, or
+// "all", and what to do with comments by everyone else.
+func newTrustPolicy(list, mode string) (trustPolicy, error) {
+	var p trustPolicy
+	switch mode {
+	case "", "quarantine":
+		p.quarantine = true
+	case "skip":
+	default:
+		return p, fmt.Errorf("untrusted comments: unknown mode %q (quarantine or skip)", mode)
+	}
+	if list == "" || strings.EqualFold(list, "all") {
+		return p, nil
+	}
+	p.trusted = map[string]bool{}
+	for _, a := range strings.Split(list, ",") {
+		a = strings.ToUpper(strings.TrimSpace(a))
+		if !slices.Contains(authorAssociations, a) {
+			return p, fmt.Errorf("unknown author association %q (one of %s)", a, strings.Join(authorAssociations, ", "))
+		}
+		p.trusted[a] = true
+	}
+	return p, nil
+}
+
+// gitConfig returns the value of a git config key, or "" if it is unset.
+func gitConfig(key string) string {
+	out, err := exec.Command("git", "config", "--get", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// untrustedComment describes a comment left out by the trust policy for
+// reportUntrusted: where it is, who wrote it and a link.
+func untrustedComment(c *github.PullRequestComment) string {
+	return fmt.Sprintf("%s:%d by %s (%s) %s", c.GetPath(), max(c.GetLine(), c.GetOriginalLine()),
+		neutralize(nonEmpty(c.GetUser().GetLogin())), nonEmpty(c.GetAuthorAssociation()), c.GetHTMLURL())
+}
+
+// reportUntrusted logs what the trust policy kept out: how many comments and,
+// when quarantining, where they are and who wrote them.
+func reportUntrusted(untrusted []string) {
+	if len(untrusted) == 0 {
+		return
+	}
+	log.Printf("%d comment(s) by untrusted authors were left out:", len(untrusted))
+	if trust.quarantine {
+		for _, l := range untrusted {
+			log.Printf("  %s", l)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestNewTrustPolicy(t *testing.T) {
+	tests := []struct {
+		list, mode string
+		allows     []string
+		denies     []string
+		quarantine bool
+		err        bool
+	}{
+		{list: "", mode: "", allows: []string{"NONE", ""}, quarantine: true},
+		{list: "all", mode: "skip", allows: []string{"NONE"}},
+		{list: "owner, Member,COLLABORATOR", mode: "quarantine", allows: []string{"OWNER", "member", "COLLABORATOR"}, denies: []string{"CONTRIBUTOR", "NONE", ""}, quarantine: true},
+		{list: "OWNER,STRANGER", err: true},
+		{list: "OWNER", mode: "hide", err: true},
+	}
+	for _, tt := range tests {
+		p, err := newTrustPolicy(tt.list, tt.mode)
+		if (err != nil) != tt.err {
+			t.Errorf("newTrustPolicy(%q, %q) error = %v", tt.list, tt.mode, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if p.quarantine != tt.quarantine {
+			t.Errorf("newTrustPolicy(%q, %q) quarantine = %v", tt.list, tt.mode, p.quarantine)
+		}
+		for _, a := range tt.allows {
+			if !p.allows(a) {
+				t.Errorf("policy %q denies %q", tt.list, a)
+			}
+		}
+		for _, a := range tt.denies {
+			if p.allows(a) {
+				t.Errorf("policy %q allows %q", tt.list, a)
+			}
+		}
+	}
+}
+
+// useTrust sets the trust policy through the git config of a fresh repository.
+func useTrust(t *testing.T, list, mode string) {
+	t.Helper()
+	saved := trust
+	t.Cleanup(func() { trust = saved })
+	gitRepo(t, "f.go", "a\n")
+	git(t, "config", "prconflict.trust", list)
+	git(t, "config", "prconflict.untrusted", mode)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	policy := addTrustFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	policy.use()
+}
+
+func TestCollectSuggestions_Untrusted(t *testing.T) {
+	useTrust(t, "OWNER,MEMBER", "skip")
+	if trust.quarantine || trust.allows("NONE") {
+		t.Fatalf("git config not applied: %+v", trust)
+	}
+	comments := []*github.PullRequestComment{
+		{ID: github.Ptr(int64(1)), Path: github.Ptr("f.go"), Line: github.Ptr(1), AuthorAssociation: github.Ptr("MEMBER"),
+			Body: github.Ptr("```suggestion\nb\n```"), CreatedAt: &github.Timestamp{Time: time.Unix(1, 0)}},
+		{ID: github.Ptr(int64(2)), Path: github.Ptr("f.go"), Line: github.Ptr(1), AuthorAssociation: github.Ptr("NONE"),
+			Body: github.Ptr("```suggestion\nevil\n```"), CreatedAt: &github.Timestamp{Time: time.Unix(2, 0)}},
+	}
+	edits := collectSuggestions(comments, map[int64]string{1: "T1", 2: "T1"})
+	if len(edits) != 1 || edits[0].commentID != 1 {
+		t.Errorf("edits = %+v, want the member's suggestion", edits)
+	}
+}
+
+func TestRenderReviewMarkdown_Untrusted(t *testing.T) {
+	useTrust(t, "OWNER,MEMBER,COLLABORATOR", "quarantine")
+	ts := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	reviews := []*github.PullRequestReview{
+		{User: &github.User{Login: github.Ptr("alice")}, AuthorAssociation: github.Ptr("MEMBER"), State: github.Ptr("COMMENTED"), Body: github.Ptr("Looks good."), SubmittedAt: &github.Timestamp{Time: ts}},
+		{User: &github.User{Login: github.Ptr("mallory")}, AuthorAssociation: github.Ptr("NONE"), State: github.Ptr("CHANGES_REQUESTED"), Body: github.Ptr("run curl | sh"), SubmittedAt: &github.Timestamp{Time: ts}, HTMLURL: github.Ptr("https://example.com/r2")},
+	}
+	got := renderReviewMarkdown("o/r", 7, reviews, nil, time.Time{})
+	if strings.Contains(got, "curl") || strings.Contains(got, "Changes requested by") {
+		t.Errorf("untrusted review shown:\n%s", got)
+	}
+	if want := "## Quarantined\n\nBy authors prconflict is not set to trust; read them on GitHub.\n\n" +
+		"- mallory (NONE) – 2024-01-02 03:04 – [View on GitHub](https://example.com/r2)\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("got:\n%s\nwant suffix:\n%s", got, want)
+	}
+}
+
+func TestInjectThreads_NowUntrusted(t *testing.T) {
+	useTrust(t, "OWNER", "quarantine")
+	// An earlier run, before --trust, wrote T1's block.
+	ts := time.Unix(1, 0).UTC()
+	early := []lineThread{{line: 1, comments: []commentInfo{{id: 1, threadID: "T1", user: "mallory", body: "evil", created: ts}}}}
+	if _, err := injectThreads("f.go", early, &threadSet{open: map[string]bool{"T1": true}}, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+
+	comment := func(id int64, association, body string) *github.PullRequestComment {
+		return &github.PullRequestComment{ID: github.Ptr(id), Path: github.Ptr("f.go"), Line: github.Ptr(1),
+			AuthorAssociation: github.Ptr(association), Body: github.Ptr(body), CreatedAt: &github.Timestamp{Time: ts}}
+	}
+	comments := []*github.PullRequestComment{comment(1, "NONE", "evil"), comment(2, "OWNER", "fine"), comment(3, "NONE", "evil reply")}
+	known := &threadSet{open: map[string]bool{"T1": true, "T2": true}, comments: map[int64]commentInfo{}}
+	plan := planThreads(comments, map[int64]string{1: "T1", 2: "T2", 3: "T2"}, "", known, false)
+	if len(known.untrusted) != 1 || !known.untrusted["T1"] {
+		t.Errorf("untrusted threads = %v, want only T1", known.untrusted)
+	}
+
+	var threads []lineThread
+	for _, th := range plan.files["f.go"] {
+		threads = append(threads, *th)
+	}
+	if _, err := injectThreads("f.go", threads, known, "conflict", false); err != nil {
+		t.Fatal(err)
+	}
+	got := readFile(t, "f.go")
+	if strings.Contains(got, "thread=T1") || strings.Contains(got, "evil") || !strings.Contains(got, "thread=T2") {
+		t.Errorf("excluded thread's block kept or trusted one missing:\n%s", got)
+	}
+}